@@ -10,29 +10,37 @@ import (
 	"net/http"
 
 	"github.com/rollerderby/go/entity"
+	"github.com/rollerderby/go/state"
 )
 
+// AddUser creates a user, or on any error leaves Users unchanged
 func (h *RootUsers) AddUser(username, password string, isSuper bool, groups []string, personID string) (*User, error) {
-	user, err := h.New(username)
-	if err != nil {
-		return nil, err
-	}
+	var user *User
+	err := state.Root.Transaction(func() error {
+		var err error
+		if user, err = h.New(username); err != nil {
+			return err
+		}
 
-	if err = user.SetUsername(username); err != nil {
-		return user, err
-	}
-	if err = user.SetIsSuper(isSuper); err != nil {
-		return user, err
-	}
-	if err = user.SetPersonID(personID); err != nil {
-		return user, err
-	}
-	for _, group := range groups {
-		if err := user.Groups().Add(group); err != nil {
-			return user, err
+		if err = user.SetUsername(username); err != nil {
+			return err
 		}
+		if err = user.SetIsSuper(isSuper); err != nil {
+			return err
+		}
+		if err = user.SetPersonID(personID); err != nil {
+			return err
+		}
+		for _, group := range groups {
+			if err := user.Groups().Add(group); err != nil {
+				return err
+			}
+		}
+		return user.SetPassword(password)
+	})
+	if err != nil {
+		return nil, err
 	}
-	user.SetPassword(password)
 
 	return user, nil
 }
@@ -1,6 +1,10 @@
 package auth
 
-import "net/http"
+import (
+	"net/http"
+
+	"github.com/rollerderby/go/state"
+)
 
 type handler struct {
 	handler http.Handler
@@ -42,7 +46,9 @@ func (h *handler) IsUserAllowed(u *User) bool {
 }
 
 func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	state.Root.RLock()
 	user, allowed := h.IsAllowed(r)
+	state.Root.RUnlock()
 	if !allowed {
 		if user == nil {
 			http.SetCookie(w, &http.Cookie{Name: "auth_redirect", Value: r.URL.String(), MaxAge: 0, Path: "/"})
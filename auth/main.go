@@ -108,14 +108,22 @@ func authHandler(w http.ResponseWriter, r *http.Request) {
 		redir = "/"
 	}
 
-	if user := CheckAuth(r); user == nil {
+	state.Root.RLock()
+	user := CheckAuth(r)
+	state.Root.RUnlock()
+
+	if user == nil {
 		// Not authenticated, check for login attempt
 		switch r.URL.Path {
 		case "/auth/":
 			username := r.PostFormValue("username")
 			password := r.PostFormValue("password")
 			if username != "" || password != "" {
-				if user, err := Authenticate(w, r, username, password); err != nil {
+				state.Root.RLock()
+				user, err := Authenticate(w, r, username, password)
+				state.Root.RUnlock()
+
+				if err != nil {
 					log.Error(err)
 				} else if user != nil {
 					http.SetCookie(w, &http.Cookie{Name: "auth_redirect", MaxAge: -1, Path: "/"})
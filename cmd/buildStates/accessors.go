@@ -95,7 +95,7 @@ func arrayAccessor(w io.Writer, tDef *typeDef) {
 					fmt.Fprintf(w, "func (h *%v) FindBy%v(lookFor %v) []*%v {\n", tDef.accessorStruct, field.name, goType, tDef.childType)
 					fmt.Fprintf(w, "	var ret []*%v\n", tDef.childType)
 					fmt.Fprintf(w, "	for _, obj := range h.Values() {\n")
-					fmt.Fprintf(w, "		if obj.%v() == lookFor {\n", field.name)
+					fmt.Fprintf(w, "		if %v {\n", equalExpr(field.stateType, "obj."+field.name+"()", "lookFor"))
 					fmt.Fprintf(w, "			ret = append(ret, obj)\n")
 					fmt.Fprintf(w, "		}\n")
 					fmt.Fprintf(w, "	}\n")
@@ -157,19 +157,26 @@ func hashAccessor(w io.Writer, tDef *typeDef) {
 			goType := goType(field.stateType)
 			if goType != "" {
 				if idx == 0 && field.name == "ID" && field.stateType == "GUID" {
+					// Elements are keyed by their ID
 					fmt.Fprintf(w, "func (h *%v) Get(id %v) *%v{\n", tDef.accessorStruct, goType, tDef.childType)
-					fmt.Fprintf(w, "	for _, obj := range h.Values() {\n")
-					fmt.Fprintf(w, "		if obj.%v() == id {\n", field.name)
-					fmt.Fprintf(w, "			return obj\n")
-					fmt.Fprintf(w, "		}\n")
+					fmt.Fprintf(w, "	if obj := h.state.Get(id); obj != nil {\n")
+					fmt.Fprintf(w, "		return new%v(obj.(%v))\n", tDef.childType, childStateType)
 					fmt.Fprintf(w, "	}\n")
 					fmt.Fprintf(w, "	return nil\n")
 					fmt.Fprintf(w, "}\n\n")
+				} else if field.index {
+					fmt.Fprintf(w, "func (h *%v) FindBy%v(lookFor %v) []*%v{\n", tDef.accessorStruct, field.name, goType, tDef.childType)
+					fmt.Fprintf(w, "	var ret []*%v\n", tDef.childType)
+					fmt.Fprintf(w, "	for _, obj := range h.state.FindBy(%#v, lookFor) {\n", field.name)
+					fmt.Fprintf(w, "		ret = append(ret, new%v(obj.(%v)))\n", tDef.childType, childStateType)
+					fmt.Fprintf(w, "	}\n")
+					fmt.Fprintf(w, "	return ret\n")
+					fmt.Fprintf(w, "}\n\n")
 				} else {
 					fmt.Fprintf(w, "func (h *%v) FindBy%v(lookFor %v) []*%v{\n", tDef.accessorStruct, field.name, goType, tDef.childType)
 					fmt.Fprintf(w, "	var ret []*%v\n", tDef.childType)
 					fmt.Fprintf(w, "	for _, obj := range h.Values() {\n")
-					fmt.Fprintf(w, "		if obj.%v() == lookFor {\n", field.name)
+					fmt.Fprintf(w, "		if %v {\n", equalExpr(field.stateType, "obj."+field.name+"()", "lookFor"))
 					fmt.Fprintf(w, "			ret = append(ret, obj)\n")
 					fmt.Fprintf(w, "		}\n")
 					fmt.Fprintf(w, "	}\n")
@@ -191,6 +198,17 @@ func objectAccessor(w io.Writer, tDef *typeDef) {
 			fmt.Fprintf(w, "func (h *%v) Set%v(val %v) error {", tDef.accessorStruct, field.name, goType)
 			fmt.Fprintf(w, "	return h.state.Get(%#v).(*state.%v).SetValue(val)\n", field.name, field.stateType)
 			fmt.Fprintf(w, "}\n\n")
+			fmt.Fprintf(w, "func (h *%v) Set%vIfUnchanged(val %v, rev uint64) error {", tDef.accessorStruct, field.name, goType)
+			fmt.Fprintf(w, "	value := h.state.Get(%#v).(*state.%v)\n", field.name, field.stateType)
+			fmt.Fprintf(w, "	if err := state.CheckRevision(value, rev); err != nil {\n")
+			fmt.Fprintf(w, "		return err\n")
+			fmt.Fprintf(w, "	}\n")
+			fmt.Fprintf(w, "	return value.SetValue(val)\n")
+			fmt.Fprintf(w, "}\n\n")
+		} else if field.stateType == "Computed" {
+			fmt.Fprintf(w, "func (h *%v) %v() *state.Computed {", tDef.accessorStruct, field.name)
+			fmt.Fprintf(w, "	return h.state.Get(%#v).(*state.Computed)\n", field.name)
+			fmt.Fprintf(w, "}\n\n")
 		} else {
 			if field.accessorName != "" {
 				fmt.Fprintf(w, "func (h *%v) %v() *%v{", tDef.accessorStruct, field.name, field.accessorName)
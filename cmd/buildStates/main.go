@@ -60,11 +60,18 @@ func main() {
 		goFile := path.Join(*dir, "state.go")
 		log.Debugf("Building state file to %q", goFile)
 
-		fmt.Fprintf(buf, "package %v\n\nimport \"github.com/rollerderby/go/state\"\n\n", *packageName)
+		body := &bytes.Buffer{}
+		buildStates(body)
+		buildAccessors(body)
+
+		fmt.Fprintf(buf, "package %v\n\n", *packageName)
+		if usesTime(typeDefs) {
+			fmt.Fprintf(buf, "import (\n\"time\"\n\n\"github.com/rollerderby/go/state\"\n)\n\n")
+		} else {
+			fmt.Fprintf(buf, "import \"github.com/rollerderby/go/state\"\n\n")
+		}
 		fmt.Fprintf(buf, "// Auto generated using buildStates command\n\n")
-
-		buildStates(buf)
-		buildAccessors(buf)
+		buf.Write(body.Bytes())
 
 		if err := saveGoCode(goFile, buf.Bytes()); err != nil {
 			log.Errorf("Error saving go code: %v", err)
@@ -78,9 +85,18 @@ type typeDef struct {
 	savePath       string
 	stateType      string
 	childType      string
+	compute        string
+	references     string
+	onDelete       string
 	initFunc       bool
+	index          bool
+	unique         bool
+	secret         bool
 	fields         []*typeDef
 	enumValues     []string
+	readGroups     []string
+	writeGroups    []string
+	constraints    map[string]json.Value
 	stateStruct    string
 	accessorName   string
 	accessorStruct string
@@ -97,6 +113,18 @@ func extractTypes(jValue json.Array, accessorPrefix string) []*typeDef {
 		delete(obj, field)
 		return val.Get()
 	}
+	getStrings := func(obj json.Object, field string) []string {
+		var ret []string
+		if vals, ok := obj[field].(json.Array); ok {
+			for _, val := range vals {
+				if val, ok := val.(*json.String); ok {
+					ret = append(ret, val.Get())
+				}
+			}
+			delete(obj, field)
+		}
+		return ret
+	}
 
 	var ret []*typeDef
 	for _, val := range jValue {
@@ -108,6 +136,9 @@ func extractTypes(jValue json.Array, accessorPrefix string) []*typeDef {
 			tDef.savePath = getString(val, "SavePath")
 			tDef.stateType = getString(val, "StateType")
 			tDef.childType = getString(val, "ChildType")
+			tDef.compute = getString(val, "Compute")
+			tDef.references = getString(val, "References")
+			tDef.onDelete = getString(val, "OnDelete")
 
 			switch tDef.stateType {
 			case "Array":
@@ -132,15 +163,28 @@ func extractTypes(jValue json.Array, accessorPrefix string) []*typeDef {
 				tDef.initFunc = initFunc == json.True
 				delete(val, "InitFunc")
 			}
-
-			if enumValues, ok := val["EnumValues"].(json.Array); ok {
-				for _, enumValue := range enumValues {
-					if enumValue, ok := enumValue.(*json.String); ok {
-						tDef.enumValues = append(tDef.enumValues, enumValue.Get())
-					}
-				}
-				delete(val, "EnumValues")
+			if index, ok := val["Index"]; ok {
+				tDef.index = index == json.True
+				delete(val, "Index")
 			}
+			if unique, ok := val["Unique"]; ok {
+				tDef.unique = unique == json.True
+				tDef.index = tDef.index || tDef.unique
+				delete(val, "Unique")
+			}
+			if secret, ok := val["Secret"]; ok {
+				tDef.secret = secret == json.True
+				delete(val, "Secret")
+			}
+			if tDef.index && goType(tDef.stateType) != "string" {
+				log.Errorf("Cannot index %v, only String, GUID and Enum fields can be indexed", tDef.name)
+				tDef.index, tDef.unique = false, false
+			}
+
+			tDef.enumValues = getStrings(val, "EnumValues")
+			tDef.readGroups = getStrings(val, "ReadGroups")
+			tDef.writeGroups = getStrings(val, "WriteGroups")
+			tDef.constraints = getConstraints(val)
 
 			if fields, ok := val["Fields"].(json.Array); ok {
 				tDef.fields = extractTypes(fields, tDef.name+"_")
@@ -161,6 +205,22 @@ func extractTypes(jValue json.Array, accessorPrefix string) []*typeDef {
 	return ret
 }
 
+var constraintNames = []string{"Min", "Max", "MaxLength", "Pattern", "Required", "Default"}
+
+func getConstraints(obj json.Object) map[string]json.Value {
+	var ret map[string]json.Value
+	for _, name := range constraintNames {
+		if val, ok := obj[name]; ok {
+			if ret == nil {
+				ret = make(map[string]json.Value)
+			}
+			ret[name] = val
+			delete(obj, name)
+		}
+	}
+	return ret
+}
+
 func saveGoCode(filename string, data []byte) error {
 	in := &bytes.Buffer{}
 	out := &bytes.Buffer{}
@@ -185,11 +245,17 @@ func goType(st string) string {
 	case "Bool":
 		return "bool"
 	case "Date":
-		return "string"
+		return "time.Time"
+	case "DateTime":
+		return "time.Time"
+	case "Duration":
+		return "time.Duration"
 	case "Enum":
 		return "string"
 	case "GUID":
 		return "string"
+	case "Float":
+		return "float64"
 	case "Number":
 		return "int64"
 	case "String":
@@ -198,6 +264,36 @@ func goType(st string) string {
 	return ""
 }
 
+// usesTime reports if the accessors generated for defs use the time package
+func usesTime(defs []*typeDef) bool {
+	for _, tDef := range defs {
+		switch tDef.stateType {
+		case "Object":
+			for _, field := range tDef.fields {
+				if strings.HasPrefix(goType(field.stateType), "time.") {
+					return true
+				}
+			}
+		case "Array":
+			if strings.HasPrefix(goType(tDef.childType), "time.") {
+				return true
+			}
+		}
+		if usesTime(tDef.fields) {
+			return true
+		}
+	}
+	return false
+}
+
+// equalExpr compares a and b, two values of state type st
+func equalExpr(st, a, b string) string {
+	if goType(st) == "time.Time" {
+		return fmt.Sprintf("%v.Equal(%v)", a, b)
+	}
+	return fmt.Sprintf("%v == %v", a, b)
+}
+
 func isSimpleType(t string) bool {
 	return goType(t) != ""
 }
@@ -3,6 +3,12 @@ package main
 import (
 	"fmt"
 	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/rollerderby/go/json"
 )
 
 func buildStates(w io.Writer) {
@@ -23,29 +29,23 @@ func buildStates(w io.Writer) {
 			continue
 		}
 		fmt.Fprintf(w, "\n%v = new%v(new%v().(*state.%v))\n", tDef.accessorName, tDef.accessorStruct, tDef.stateStruct, tDef.stateType)
+		if len(tDef.readGroups) > 0 {
+			fmt.Fprintf(w, "%v.state.AddReadGroup(%#v...)\n", tDef.accessorName, tDef.readGroups)
+		}
+		if len(tDef.writeGroups) > 0 {
+			fmt.Fprintf(w, "%v.state.AddWriteGroup(%#v...)\n", tDef.accessorName, tDef.writeGroups)
+		}
 		fmt.Fprintf(w, "if err := state.Root.Add(%#v, %#v, %v.state); err != nil { return err }\n", tDef.root, tDef.savePath, tDef.accessorName)
 	}
 	fmt.Fprintf(w, "return nil")
 	fmt.Fprintf(w, "}")
 
 	childInit := func(tDef *typeDef) string {
-		switch tDef.childType {
-		case "Bool":
-			return "state.NewBool"
-		case "Date":
-			return "state.NewDate"
-		case "Enum":
-			return fmt.Sprintf("state.NewEnumOf(%#v...)", tDef.enumValues)
-		case "GUID":
-			return "state.NewGUID"
-		case "Number":
-			return "state.NewNumber"
-		case "String":
-			return "state.NewString"
-		default:
-			ct := findType(tDef.childType)
-			return fmt.Sprintf("new%v", ct.stateStruct)
+		if initializer := simpleInit(tDef.childType, tDef); initializer != "" {
+			return initializer
 		}
+		ct := findType(tDef.childType)
+		return fmt.Sprintf("new%v", ct.stateStruct)
 	}
 
 	for _, tDef := range typeDefs {
@@ -53,33 +53,42 @@ func buildStates(w io.Writer) {
 		switch tDef.stateType {
 		case "Hash":
 			ct := findType(tDef.childType)
-			fmt.Fprintf(w, "return state.NewHashOf(new%v)()\n", ct.stateStruct)
+			fmt.Fprintf(w, "return state.NewHashOf(new%v%v)()\n", ct.stateStruct, indexDefs(ct))
 		case "Object":
 			fmt.Fprintf(w, "return &state.Object{\n")
 			fmt.Fprintf(w, "	Definition: state.ObjectDef{\n")
 			fmt.Fprintf(w, "		Name: %#v,\n", tDef.name)
 			fmt.Fprintf(w, "		Values: []state.ObjectValueDef{\n")
 			for _, fieldDef := range tDef.fields {
+				var initializer string
 				switch fieldDef.stateType {
 				case "Array":
-					fmt.Fprintf(w, "state.ObjectValueDef{Name: %#v, Initializer: state.NewArrayOf(%v)},\n", fieldDef.name, childInit(fieldDef))
-				case "Bool":
-					fmt.Fprintf(w, "state.ObjectValueDef{Name: %#v, Initializer: state.NewBool},\n", fieldDef.name)
-				case "Date":
-					fmt.Fprintf(w, "state.ObjectValueDef{Name: %#v, Initializer: state.NewDate},\n", fieldDef.name)
-				case "Enum":
-					fmt.Fprintf(w, "state.ObjectValueDef{Name: %#v, Initializer: state.NewEnumOf(%#v...)},\n", fieldDef.name, fieldDef.enumValues)
-				case "GUID":
-					fmt.Fprintf(w, "state.ObjectValueDef{Name: %#v, Initializer: state.NewGUID},\n", fieldDef.name)
+					initializer = fmt.Sprintf("state.NewArrayOf(%v)", childInit(fieldDef))
 				case "Hash":
-					fmt.Fprintf(w, "state.ObjectValueDef{Name: %#v, Initializer: state.NewHashOf(%v)},\n", fieldDef.name, childInit(fieldDef))
-				case "Number":
-					fmt.Fprintf(w, "state.ObjectValueDef{Name: %#v, Initializer: state.NewNumber},\n", fieldDef.name)
-				case "String":
-					fmt.Fprintf(w, "state.ObjectValueDef{Name: %#v, Initializer: state.NewString},\n", fieldDef.name)
+					initializer = fmt.Sprintf("state.NewHashOf(%v%v)", childInit(fieldDef), indexDefs(findType(fieldDef.childType)))
+				case "Computed":
+					if fieldDef.compute == "" {
+						log.Errorf("Computed %v.%v needs a Compute function", tDef.name, fieldDef.name)
+						continue
+					}
+					initializer = fmt.Sprintf("state.NewComputed(%v)", fieldDef.compute)
 				default:
-					log.Errorf("Unknown StateType(%q) in %v.%v", fieldDef.stateType, tDef.name, fieldDef.name)
+					if initializer = simpleInit(fieldDef.stateType, fieldDef); initializer == "" {
+						log.Errorf("Unknown StateType(%q) in %v.%v", fieldDef.stateType, tDef.name, fieldDef.name)
+						continue
+					}
+				}
+				fmt.Fprintf(w, "state.ObjectValueDef{Name: %#v, Initializer: %v", fieldDef.name, initializer)
+				if len(fieldDef.readGroups) > 0 {
+					fmt.Fprintf(w, ", ReadGroups: %#v", fieldDef.readGroups)
+				}
+				if len(fieldDef.writeGroups) > 0 {
+					fmt.Fprintf(w, ", WriteGroups: %#v", fieldDef.writeGroups)
+				}
+				if fieldDef.secret {
+					fmt.Fprintf(w, ", Secret: true")
 				}
+				fmt.Fprintf(w, "},\n")
 			}
 			fmt.Fprintf(w, "		},")
 			fmt.Fprintf(w, "	},")
@@ -91,3 +100,202 @@ func buildStates(w io.Writer) {
 		fmt.Fprintf(w, "}")
 	}
 }
+
+// simpleInit returns the initializer for a simple state type, applying any
+// constraints in tDef, or "" if st is not a simple type
+func simpleInit(st string, tDef *typeDef) string {
+	switch st {
+	case "Bool":
+		checkConstraints(tDef)
+		return "state.NewBool"
+	case "Date":
+		checkConstraints(tDef)
+		return "state.NewDate"
+	case "DateTime":
+		checkConstraints(tDef)
+		return "state.NewDateTime"
+	case "Duration":
+		checkConstraints(tDef)
+		return "state.NewDuration"
+	case "Enum":
+		checkConstraints(tDef)
+		return fmt.Sprintf("state.NewEnumOf(%#v...)", tDef.enumValues)
+	case "GUID":
+		checkConstraints(tDef)
+		return guidInit(tDef)
+	case "Float":
+		return numberInit(tDef, "Float", "Float64")
+	case "Number":
+		return numberInit(tDef, "Number", "Int64")
+	case "String":
+		return stringInit(tDef)
+	}
+	return ""
+}
+
+// checkConstraints logs any constraint on tDef not in allowed
+func checkConstraints(tDef *typeDef, allowed ...string) {
+	for name := range tDef.constraints {
+		ok := false
+		for _, a := range allowed {
+			ok = ok || a == name
+		}
+		if !ok {
+			log.Errorf("Constraint %v is not supported on %v", name, tDef.name)
+		}
+	}
+}
+
+// numberInit builds the initializer for Number or Float, kind is the go
+// type name used for the Min and Max pointers
+func numberInit(tDef *typeDef, st, kind string) string {
+	if len(tDef.constraints) == 0 {
+		return "state.New" + st
+	}
+	checkConstraints(tDef, "Min", "Max", "Default")
+
+	nums := make(map[string]*json.Number)
+	values := make(map[string]float64)
+	for _, name := range []string{"Min", "Max", "Default"} {
+		val, ok := tDef.constraints[name]
+		if !ok {
+			continue
+		}
+		num, ok := val.(*json.Number)
+		if !ok {
+			log.Errorf("Constraint %v on %v must be a number", name, tDef.name)
+			continue
+		}
+		var err error
+		if kind == "Int64" {
+			_, err = num.GetInt64()
+		} else {
+			_, err = num.GetFloat64()
+		}
+		if err == nil {
+			values[name], err = num.GetFloat64()
+		}
+		if err != nil {
+			log.Errorf("Constraint %v on %v: %v", name, tDef.name, err)
+			continue
+		}
+		nums[name] = num
+	}
+
+	// The same checks as state.Number
+	if def, ok := values["Default"]; ok {
+		if min, ok := values["Min"]; ok && def < min {
+			log.Errorf("Default %v on %v is less than Min %v", nums["Default"], tDef.name, nums["Min"])
+			delete(nums, "Default")
+		} else if max, ok := values["Max"]; ok && def > max {
+			log.Errorf("Default %v on %v is greater than Max %v", nums["Default"], tDef.name, nums["Max"])
+			delete(nums, "Default")
+		}
+	}
+
+	var fields []string
+	for _, name := range []string{"Min", "Max", "Default"} {
+		num, ok := nums[name]
+		if !ok {
+			continue
+		}
+		if name == "Default" {
+			fields = append(fields, fmt.Sprintf("Default: %v", num))
+		} else {
+			fields = append(fields, fmt.Sprintf("%v: state.%v(%v)", name, kind, num))
+		}
+	}
+	return fmt.Sprintf("state.New%vOf(state.%vDef{%v})", st, st, strings.Join(fields, ", "))
+}
+
+func stringInit(tDef *typeDef) string {
+	if len(tDef.constraints) == 0 {
+		return "state.NewString"
+	}
+	checkConstraints(tDef, "Pattern", "MaxLength", "Required", "Default")
+
+	var fields []string
+	var pattern *regexp.Regexp
+	var maxLength int64
+	var def *string
+	for name, val := range tDef.constraints {
+		switch name {
+		case "Pattern", "Default":
+			str, ok := val.(*json.String)
+			if !ok {
+				log.Errorf("Constraint %v on %v must be a string", name, tDef.name)
+			} else if name == "Default" {
+				s := str.Get()
+				def = &s
+			} else if re, err := regexp.Compile("^(?:" + str.Get() + ")$"); err != nil {
+				log.Errorf("Invalid Pattern on %v: %v", tDef.name, err)
+			} else {
+				pattern = re
+				fields = append(fields, fmt.Sprintf("Pattern: %#v", str.Get()))
+			}
+		case "MaxLength":
+			num, ok := val.(*json.Number)
+			if !ok {
+				log.Errorf("Constraint %v on %v must be a number", name, tDef.name)
+			} else if i, err := num.GetInt64(); err != nil {
+				log.Errorf("Constraint %v on %v: %v", name, tDef.name, err)
+			} else {
+				maxLength = i
+				fields = append(fields, fmt.Sprintf("MaxLength: %v", i))
+			}
+		case "Required":
+			if val == json.True {
+				fields = append(fields, "Required: true")
+			}
+		}
+	}
+
+	// The same checks as state.String, except that an empty Default is fine
+	// for a Required string since new elements are filled in afterwards
+	if def != nil {
+		if maxLength > 0 && int64(utf8.RuneCountInString(*def)) > maxLength {
+			log.Errorf("Default %q on %v is longer than MaxLength %v", *def, tDef.name, maxLength)
+		} else if pattern != nil && *def != "" && !pattern.MatchString(*def) {
+			log.Errorf("Default %q on %v does not match Pattern", *def, tDef.name)
+		} else {
+			fields = append(fields, fmt.Sprintf("Default: %#v", *def))
+		}
+	}
+	sort.Strings(fields)
+	return fmt.Sprintf("state.NewStringOf(state.StringDef{%v})", strings.Join(fields, ", "))
+}
+
+func guidInit(tDef *typeDef) string {
+	if tDef.references == "" {
+		if tDef.onDelete != "" {
+			log.Errorf("OnDelete on %v needs References", tDef.name)
+		}
+		return "state.NewGUID"
+	}
+
+	onDelete := tDef.onDelete
+	switch onDelete {
+	case "":
+		onDelete = "Restrict"
+	case "Restrict", "Cascade", "Nullify":
+	default:
+		log.Errorf("Unknown OnDelete(%q) on %v", onDelete, tDef.name)
+		onDelete = "Restrict"
+	}
+	return fmt.Sprintf("state.NewGUIDRef(%#v, state.Ref%v)", tDef.references, onDelete)
+}
+
+// indexDefs returns the extra NewHashOf arguments indexing the fields of an
+// Object type
+func indexDefs(tDef *typeDef) string {
+	if tDef == nil {
+		return ""
+	}
+	var ret string
+	for _, field := range tDef.fields {
+		if field.index {
+			ret += fmt.Sprintf(", state.IndexDef{Field: %#v, Unique: %v}", field.name, field.unique)
+		}
+	}
+	return ret
+}
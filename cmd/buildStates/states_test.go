@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/rollerderby/go/json"
+)
+
+func TestStringInitDefault(t *testing.T) {
+	tests := []struct {
+		constraints json.Object
+		expected    string
+	}{
+		{json.Object{"Default": json.NewString("abc"), "MaxLength": json.NewNumber(3)}, `state.NewStringOf(state.StringDef{Default: "abc", MaxLength: 3})`},
+		{json.Object{"Default": json.NewString("abcd"), "MaxLength": json.NewNumber(3)}, `state.NewStringOf(state.StringDef{MaxLength: 3})`},
+		{json.Object{"Default": json.NewString("a1"), "Pattern": json.NewString("[a-z]+")}, `state.NewStringOf(state.StringDef{Pattern: "[a-z]+"})`},
+		{json.Object{"Default": json.NewString(""), "Required": json.True}, `state.NewStringOf(state.StringDef{Default: "", Required: true})`},
+	}
+	for _, test := range tests {
+		tDef := &typeDef{name: "Test", stateType: "String", constraints: test.constraints}
+		if init := stringInit(tDef); init != test.expected {
+			t.Fatalf("Expected %v, got %v", test.expected, init)
+		}
+	}
+}
+
+func TestNumberInitDefault(t *testing.T) {
+	tests := []struct {
+		constraints json.Object
+		st, kind    string
+		expected    string
+	}{
+		{json.Object{"Default": json.NewNumber(5), "Min": json.NewNumber(1), "Max": json.NewNumber(10)}, "Number", "Int64", `state.NewNumberOf(state.NumberDef{Min: state.Int64(1), Max: state.Int64(10), Default: 5})`},
+		{json.Object{"Default": json.NewNumber(0), "Min": json.NewNumber(1)}, "Number", "Int64", `state.NewNumberOf(state.NumberDef{Min: state.Int64(1)})`},
+		{json.Object{"Default": json.NewNumber(11), "Max": json.NewNumber(10)}, "Number", "Int64", `state.NewNumberOf(state.NumberDef{Max: state.Int64(10)})`},
+		{json.Object{"Default": json.NewNumber(10), "Max": json.NewNumber(10)}, "Number", "Int64", `state.NewNumberOf(state.NumberDef{Max: state.Int64(10), Default: 10})`},
+		{json.Object{"Default": json.NewFloat(0.5), "Min": json.NewNumber(1)}, "Float", "Float64", `state.NewFloatOf(state.FloatDef{Min: state.Float64(1)})`},
+	}
+	for _, test := range tests {
+		tDef := &typeDef{name: "Test", stateType: test.st, constraints: test.constraints}
+		if init := numberInit(tDef, test.st, test.kind); init != test.expected {
+			t.Fatalf("Expected %v, got %v", test.expected, init)
+		}
+	}
+}
+
+func TestUsesTime(t *testing.T) {
+	obj := &typeDef{stateType: "Object", fields: []*typeDef{{stateType: "String"}}}
+	if usesTime([]*typeDef{obj}) {
+		t.Fatal("No time fields")
+	}
+	obj.fields = append(obj.fields, &typeDef{stateType: "Array", childType: "Date"})
+	if !usesTime([]*typeDef{obj}) {
+		t.Fatal("Array of Date uses time")
+	}
+}
@@ -2,16 +2,37 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"os"
+	"time"
 
 	"github.com/rollerderby/go/logger"
 	"github.com/rollerderby/go/server"
+	"github.com/rollerderby/go/state"
 )
 
 func main() {
 	port := flag.Int("port", 8000, "Port to listen on")
 	verbose := flag.Bool("v", false, "Print debugging information")
+	backups := flag.Int("backups", 10, "Number of automatic config backups to keep, 0 to disable")
+	backupInterval := flag.Duration("backup-interval", time.Hour, "Time between automatic config backups")
+	auditFiles := flag.Int("audit-files", 10, "Number of audit log files to keep, 0 to disable the audit log")
+	auditSize := flag.Int64("audit-size", 10*1024*1024, "Size in bytes an audit log file can grow to before it is rolled")
+	storage := flag.String("storage", "dir", "How to store configs: dir (one JSON file each) or bolt (single database file)")
 	flag.Parse()
 
+	state.Root.SetBackupPolicy(*backups, *backupInterval)
+	state.Root.SetAuditPolicy(*auditFiles, *auditSize)
+	switch *storage {
+	case "dir":
+		state.Root.SetStorage(state.NewDirStorage)
+	case "bolt":
+		state.Root.SetStorage(state.NewBoltStorage)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown storage %q\n", *storage)
+		os.Exit(1)
+	}
+
 	if *verbose {
 		logger.SetLevel(logger.DEBUG)
 	}
@@ -13,6 +13,10 @@ func Initialize() error {
 		log.Critf("Error initializing state: %v", err)
 		return err
 	}
+	if err := addMigrations(); err != nil {
+		log.Critf("Error adding migrations: %v", err)
+		return err
+	}
 
 	return nil
 }
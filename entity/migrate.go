@@ -0,0 +1,69 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/rollerderby/go/json"
+	"github.com/rollerderby/go/state"
+)
+
+// Cert dates used to be free-form strings.  Dates are now saved as
+// "2006-01-02", so older files have them rewritten in that form.  A date in
+// none of the legacy layouts is cleared and logged, the backup taken before
+// migrating still has it.
+var legacyDateLayouts = []string{
+	"2006-01-02",
+	time.RFC3339,
+	"2006/01/02",
+	"01/02/2006",
+	"1/2/2006",
+	"01-02-2006",
+	"January 2, 2006",
+	"Jan 2, 2006",
+	"2 January 2006",
+	"2 Jan 2006",
+}
+
+func addMigrations() error {
+	return state.Root.AddMigration("People", 0, migrateCertDates)
+}
+
+// migrateCertDates upgrades a person from schema version 0 to 1
+func migrateCertDates(key string, j json.Object) (json.Object, error) {
+	certs, ok := j["Certs"].(json.Object)
+	if !ok {
+		return j, nil
+	}
+	for certKey, jCert := range certs {
+		cert, ok := jCert.(json.Object)
+		if !ok {
+			continue
+		}
+		for _, field := range []string{"StartDate", "EndDate"} {
+			str, ok := cert[field].(*json.String)
+			if !ok {
+				continue
+			}
+			date, ok := parseLegacyDate(str.Get())
+			if !ok {
+				log.Errorf("People[%v].Certs[%v]: dropping unreadable %v %q", key, certKey, field, str.Get())
+			}
+			cert[field] = json.NewString(date)
+		}
+	}
+	return j, nil
+}
+
+// parseLegacyDate returns val as "2006-01-02", or "" and false if it is not
+// a date
+func parseLegacyDate(val string) (string, bool) {
+	if val == "" {
+		return "", true
+	}
+	for _, layout := range legacyDateLayouts {
+		if t, err := time.Parse(layout, val); err == nil {
+			return t.Format("2006-01-02"), true
+		}
+	}
+	return "", false
+}
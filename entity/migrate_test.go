@@ -0,0 +1,24 @@
+package entity
+
+import (
+	"testing"
+
+	"github.com/rollerderby/go/json"
+)
+
+func TestMigrateCertDates(t *testing.T) {
+	cert := json.Object{
+		"StartDate": json.NewString("3/14/2015"),
+		"EndDate":   json.NewString("sometime"),
+	}
+	j := json.Object{"Certs": json.Object{"c": cert}}
+	if _, err := migrateCertDates("p", j); err != nil {
+		t.Fatal(err)
+	}
+	if got := cert["StartDate"].(*json.String).Get(); got != "2015-03-14" {
+		t.Fatalf("StartDate is %q", got)
+	}
+	if got := cert["EndDate"].(*json.String).Get(); got != "" {
+		t.Fatalf("EndDate is %q", got)
+	}
+}
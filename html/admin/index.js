@@ -0,0 +1,85 @@
+function backups(msg) {
+	var table = $("table.backups").empty();
+	if (msg.data == null) {
+		return;
+	}
+	for (var i = msg.data.length - 1; i >= 0; i--) {
+		var backup = msg.data[i];
+		var restore = $("<button>").text("Restore").data("name", backup.Name).click(function() {
+			var name = $(this).data("name");
+			if (confirm("Replace the current config with backup " + name + "?")) {
+				control.ws.Send("RestoreBackup", name);
+			}
+		});
+		table.append($("<tr>")
+			.append($("<td>").text(new Date(backup.Time).toLocaleString()))
+			.append($("<td>").text(backup.Name))
+			.append($("<td>").append(restore)));
+	}
+}
+
+function audit(msg) {
+	var table = $("table.audit").empty();
+	if (msg.data == null || msg.data.length == 0) {
+		table.append($("<tr>").append($("<td>").text("No matching changes")));
+		return;
+	}
+	table.append($("<tr>")
+		.append($("<th>").text("Time"))
+		.append($("<th>").text("Revision"))
+		.append($("<th>").text("User"))
+		.append($("<th>").text("Address"))
+		.append($("<th>").text("Path"))
+		.append($("<th>").text("Old"))
+		.append($("<th>").text("New")));
+	// Newest first
+	for (var i = msg.data.length - 1; i >= 0; i--) {
+		var entry = msg.data[i];
+		table.append($("<tr>")
+			.append($("<td>").text(new Date(entry.Time).toLocaleString()))
+			.append($("<td>").text(entry.Revision))
+			.append($("<td>").text(entry.User))
+			.append($("<td>").text(entry.RemoteAddr))
+			.append($("<td>").text(entry.Path))
+			.append($("<td>").text(entry.Old === undefined ? "" : JSON.stringify(entry.Old)))
+			.append($("<td>").text(entry.New === undefined ? "" : JSON.stringify(entry.New))));
+	}
+}
+
+function auditQuery() {
+	var query = {};
+	var form = $("div.AuditQuery");
+	$.each(["Path", "User"], function(idx, key) {
+		var val = form.find("input." + key).val();
+		if (val != "") {
+			query[key] = val;
+		}
+	});
+	$.each(["Since", "Until"], function(idx, key) {
+		var val = form.find("input." + key).val();
+		if (val != "") {
+			query[key] = new Date(val).toISOString();
+		}
+	});
+	return query;
+}
+
+function initAdmin() {
+	control.ws.Register("Backups", backups);
+	control.ws.Register("Audit", audit);
+	control.ws.Register("Error", function(msg) {
+		alert(msg.data);
+	});
+	$("button.CreateBackup").click(function() {
+		control.ws.Send("CreateBackup");
+	});
+	$("div.AuditQuery button.Search").click(function() {
+		control.ws.Send("Audit", auditQuery());
+	});
+	// Ask again after every (re)connect
+	control.ws.options.onOpen = function() {
+		control.ws.Send("Backups");
+	};
+}
+
+$().ready(initAdmin);
@@ -281,6 +281,9 @@ function modal(headerText, div, allowClose) {
 function newControlChannel() {
 	var cc = {
 		ws: null,
+		state: {},
+		stateRevision: 0,
+		stateListeners: new Array(),
 		clear: function() {},
 
 		menuItems: function(msg) {
@@ -311,6 +314,52 @@ function newControlChannel() {
 			document.location = "/auth/?reauth";
 		},
 
+		stateUpdate: function(msg) {
+			if (msg.data == null) {
+				return;
+			}
+			for (var path in msg.data.Values) {
+				// "Ruleset[id][Name]" is stored at cc.state.Ruleset[id].Name
+				var keys = path.replace(/\]/g, "").split("[");
+				var obj = cc.state;
+				for (var i = 0; i < keys.length - 1; i++) {
+					if (obj[keys[i]] == null) {
+						obj[keys[i]] = {};
+					}
+					obj = obj[keys[i]];
+				}
+				obj[keys[keys.length - 1]] = msg.data.Values[path];
+			}
+			cc.stateRevision = msg.data.Revision;
+			for (var i = 0; i < cc.stateListeners.length; i++) {
+				cc.stateListeners[i](msg.data.Values, msg.data.Revision);
+			}
+		},
+
+		// Follow the state under paths, func is called with the changed values
+		Watch: function(paths, func) {
+			if (func != null) {
+				cc.stateListeners.push(func);
+			}
+			cc.ws.Send("Register", {
+				Paths: paths,
+				Revision: 0
+			});
+		},
+
+		Unwatch: function(paths) {
+			cc.ws.Send("Unregister", {
+				Paths: paths
+			});
+		},
+
+		Set: function(path, value) {
+			cc.ws.Send("Set", {
+				Path: path,
+				Value: value
+			});
+		},
+
 		Init: function() {
 			cc.ws = websocket("/ws/control", {
 				onError: cc.clear,
@@ -319,6 +368,7 @@ function newControlChannel() {
 			cc.ws.Register("User", cc.user);
 			cc.ws.Register("MenuItems", cc.menuItems);
 			cc.ws.Register("Reauth", cc.reauth);
+			cc.ws.Register("State", cc.stateUpdate);
 		},
 	};
 
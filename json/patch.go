@@ -0,0 +1,380 @@
+package json
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Operation is one step of an RFC 6902 JSON Patch.  Path and From are JSON
+// Pointers (RFC 6901), "" being the whole document.
+type Operation struct {
+	Op    string // add, remove, replace, move, copy or test
+	Path  string
+	From  string // Only for move and copy
+	Value Value  // Only for add, replace and test
+}
+
+type Patch []*Operation
+
+// Equal reports if a and b hold the same JSON
+func Equal(a, b Value) bool {
+	return a.JSON(false) == b.JSON(false)
+}
+
+// Copy returns a deep copy of v
+func Copy(v Value) Value {
+	switch v := v.(type) {
+	case Object:
+		ret := make(Object, len(v))
+		for key, val := range v {
+			ret[key] = Copy(val)
+		}
+		return ret
+	case Array:
+		ret := make(Array, len(v))
+		for idx, val := range v {
+			ret[idx] = Copy(val)
+		}
+		return ret
+	case *String:
+		return NewString(v.val)
+	case *Number:
+		return &Number{val: v.val}
+	}
+	return v
+}
+
+// Diff returns a patch that turns from into to.  Objects are compared key by
+// key.  Arrays are compared index by index, so inserting near the start of an
+// array replaces every element after it.
+func Diff(from, to Value) Patch {
+	return diff(nil, "", from, to)
+}
+
+func diff(p Patch, path string, from, to Value) Patch {
+	if Equal(from, to) {
+		return p
+	}
+
+	switch f := from.(type) {
+	case Object:
+		t, ok := to.(Object)
+		if !ok {
+			break
+		}
+		for _, key := range sortedKeys(f) {
+			if _, ok := t[key]; !ok {
+				p = append(p, &Operation{Op: "remove", Path: path + "/" + EscapePointer(key)})
+			}
+		}
+		for _, key := range sortedKeys(t) {
+			if val, ok := f[key]; ok {
+				p = diff(p, path+"/"+EscapePointer(key), val, t[key])
+			} else {
+				p = append(p, &Operation{Op: "add", Path: path + "/" + EscapePointer(key), Value: Copy(t[key])})
+			}
+		}
+		return p
+	case Array:
+		t, ok := to.(Array)
+		if !ok {
+			break
+		}
+		for idx := 0; idx < len(f) && idx < len(t); idx++ {
+			p = diff(p, fmt.Sprintf("%v/%v", path, idx), f[idx], t[idx])
+		}
+		for idx := len(f) - 1; idx >= len(t); idx-- {
+			p = append(p, &Operation{Op: "remove", Path: fmt.Sprintf("%v/%v", path, idx)})
+		}
+		for idx := len(f); idx < len(t); idx++ {
+			p = append(p, &Operation{Op: "add", Path: fmt.Sprintf("%v/%v", path, idx), Value: Copy(t[idx])})
+		}
+		return p
+	}
+	return append(p, &Operation{Op: "replace", Path: path, Value: Copy(to)})
+}
+
+// Apply returns doc with the patch applied.  doc itself is not changed, and
+// if any operation fails none are applied.
+func (p Patch) Apply(doc Value) (Value, error) {
+	doc = Copy(doc)
+	for _, op := range p {
+		var err error
+		if doc, err = op.apply(doc); err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+func (op *Operation) apply(doc Value) (Value, error) {
+	path, err := ParsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Op {
+	case "add":
+		if op.Value == nil {
+			return nil, op.errorf("value is missing")
+		}
+		return addValue(doc, path, Copy(op.Value), op)
+	case "remove":
+		doc, _, err = removeValue(doc, path, op)
+		return doc, err
+	case "replace":
+		if op.Value == nil {
+			return nil, op.errorf("value is missing")
+		}
+		if len(path) == 0 {
+			return Copy(op.Value), nil
+		}
+		if doc, _, err = removeValue(doc, path, op); err != nil {
+			return nil, err
+		}
+		return addValue(doc, path, Copy(op.Value), op)
+	case "move", "copy":
+		from, err := ParsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		var val Value
+		if op.Op == "move" {
+			if strings.HasPrefix(op.Path+"/", op.From+"/") && op.Path != op.From {
+				return nil, op.errorf("cannot move a value into itself")
+			}
+			doc, val, err = removeValue(doc, from, op)
+		} else {
+			val, err = getValue(doc, from, op)
+			val = Copy(val)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return addValue(doc, path, val, op)
+	case "test":
+		val, err := getValue(doc, path, op)
+		if err != nil {
+			return nil, err
+		}
+		if op.Value == nil || !Equal(val, op.Value) {
+			return nil, op.errorf("test failed")
+		}
+		return doc, nil
+	}
+	return nil, op.errorf("unknown op")
+}
+
+func (op *Operation) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("JSON Patch %v %q: %v", op.Op, op.Path, fmt.Sprintf(format, args...))
+}
+
+func getValue(doc Value, path []string, op *Operation) (Value, error) {
+	for _, token := range path {
+		switch d := doc.(type) {
+		case Object:
+			val, ok := d[token]
+			if !ok {
+				return nil, op.errorf("%q not found", token)
+			}
+			doc = val
+		case Array:
+			idx, err := arrayIndex(token, len(d)-1)
+			if err != nil {
+				return nil, op.errorf("%v", err)
+			}
+			doc = d[idx]
+		default:
+			return nil, op.errorf("%q not found", token)
+		}
+	}
+	return doc, nil
+}
+
+// addValue returns doc with val added at path.  Arrays may have to grow, so
+// the returned container must replace the original.
+func addValue(doc Value, path []string, val Value, op *Operation) (Value, error) {
+	if len(path) == 0 {
+		return val, nil
+	}
+	token, rest := path[0], path[1:]
+
+	switch d := doc.(type) {
+	case Object:
+		if len(rest) == 0 {
+			d[token] = val
+			return d, nil
+		}
+		child, ok := d[token]
+		if !ok {
+			return nil, op.errorf("%q not found", token)
+		}
+		child, err := addValue(child, rest, val, op)
+		if err != nil {
+			return nil, err
+		}
+		d[token] = child
+		return d, nil
+	case Array:
+		if len(rest) == 0 {
+			idx := len(d)
+			if token != "-" {
+				var err error
+				if idx, err = arrayIndex(token, len(d)); err != nil {
+					return nil, op.errorf("%v", err)
+				}
+			}
+			d = append(d, nil)
+			copy(d[idx+1:], d[idx:])
+			d[idx] = val
+			return d, nil
+		}
+		idx, err := arrayIndex(token, len(d)-1)
+		if err != nil {
+			return nil, op.errorf("%v", err)
+		}
+		if d[idx], err = addValue(d[idx], rest, val, op); err != nil {
+			return nil, err
+		}
+		return d, nil
+	}
+	return nil, op.errorf("%q not found", token)
+}
+
+// removeValue returns doc without the value at path, and the value removed
+func removeValue(doc Value, path []string, op *Operation) (Value, Value, error) {
+	if len(path) == 0 {
+		return nil, nil, op.errorf("cannot remove the whole document")
+	}
+	token, rest := path[0], path[1:]
+
+	switch d := doc.(type) {
+	case Object:
+		child, ok := d[token]
+		if !ok {
+			return nil, nil, op.errorf("%q not found", token)
+		}
+		if len(rest) == 0 {
+			delete(d, token)
+			return d, child, nil
+		}
+		child, removed, err := removeValue(child, rest, op)
+		if err != nil {
+			return nil, nil, err
+		}
+		d[token] = child
+		return d, removed, nil
+	case Array:
+		idx, err := arrayIndex(token, len(d)-1)
+		if err != nil {
+			return nil, nil, op.errorf("%v", err)
+		}
+		if len(rest) == 0 {
+			removed := d[idx]
+			return append(d[:idx], d[idx+1:]...), removed, nil
+		}
+		child, removed, err := removeValue(d[idx], rest, op)
+		if err != nil {
+			return nil, nil, err
+		}
+		d[idx] = child
+		return d, removed, nil
+	}
+	return nil, nil, op.errorf("%q not found", token)
+}
+
+func arrayIndex(token string, max int) (int, error) {
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	idx, err := strconv.Atoi(token)
+	if err != nil || idx < 0 || idx > max {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	return idx, nil
+}
+
+// ParsePointer splits a JSON Pointer into its unescaped reference tokens
+func ParsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if pointer[0] != '/' {
+		return nil, fmt.Errorf("Invalid JSON Pointer %q", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for idx, token := range tokens {
+		tokens[idx] = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+	}
+	return tokens, nil
+}
+
+// EscapePointer escapes a key for use as a JSON Pointer reference token
+func EscapePointer(key string) string {
+	return strings.Replace(strings.Replace(key, "~", "~0", -1), "/", "~1", -1)
+}
+
+// Array returns the patch in its JSON form
+func (p Patch) Array() Array {
+	arr := make(Array, 0, len(p))
+	for _, op := range p {
+		obj := Object{"op": NewString(op.Op), "path": NewString(op.Path)}
+		if op.Op == "move" || op.Op == "copy" {
+			obj["from"] = NewString(op.From)
+		}
+		if op.Value != nil {
+			obj["value"] = op.Value
+		}
+		arr = append(arr, obj)
+	}
+	return arr
+}
+
+// DecodePatch reads a patch from its JSON form
+func DecodePatch(v Value) (Patch, error) {
+	arr, ok := v.(Array)
+	if !ok {
+		return nil, errors.New("JSON Patch must be an array")
+	}
+
+	var p Patch
+	for idx, jOp := range arr {
+		obj, ok := jOp.(Object)
+		if !ok {
+			return nil, fmt.Errorf("JSON Patch operation %v must be an object", idx)
+		}
+		op := &Operation{Value: obj["value"]}
+		keys := []string{"op", "path"}
+		if str, ok := obj["op"].(*String); ok && (str.Get() == "move" || str.Get() == "copy") {
+			keys = append(keys, "from")
+		}
+		for _, key := range keys {
+			str, ok := obj[key].(*String)
+			if !ok {
+				return nil, fmt.Errorf("JSON Patch operation %v needs a string %q", idx, key)
+			}
+			switch key {
+			case "op":
+				op.Op = str.Get()
+			case "path":
+				op.Path = str.Get()
+			case "from":
+				op.From = str.Get()
+			}
+		}
+		p = append(p, op)
+	}
+	return p, nil
+}
+
+func sortedKeys(obj Object) []string {
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
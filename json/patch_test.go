@@ -0,0 +1,72 @@
+package json
+
+import "testing"
+
+func mustDecode(t *testing.T, data string) Value {
+	val, err := Decode([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return val
+}
+
+func TestPatchDiff(t *testing.T) {
+	tests := [][2]string{
+		{`{"a": 1, "b": [1, 2, 3], "c": {"d": "x"}}`, `{"a": 2, "b": [1, 3], "c": {"d": "x", "e/f": true}}`},
+		{`{"a": [1]}`, `{"a": [1, 2, {"b": null}]}`},
+		{`{"a~b": {"c": 1}}`, `{"a~b": "c"}`},
+		{`[1, 2]`, `{"a": 1}`},
+	}
+	for _, test := range tests {
+		from, to := mustDecode(t, test[0]), mustDecode(t, test[1])
+		patch := Diff(from, to)
+		result, err := patch.Apply(from)
+		if err != nil {
+			t.Fatalf("Cannot apply %v: %v", patch.Array().JSON(false), err)
+		}
+		if !Equal(result, to) {
+			t.Fatalf("Unexpected result.  `%v` != `%v`", result.JSON(false), to.JSON(false))
+		}
+		if from.JSON(false) != mustDecode(t, test[0]).JSON(false) {
+			t.Fatalf("Apply changed the document: `%v`", from.JSON(false))
+		}
+	}
+}
+
+func TestPatchApply(t *testing.T) {
+	doc := mustDecode(t, `{"a": {"b": [1, 2]}, "c": "d"}`)
+	patch, err := DecodePatch(mustDecode(t, `[
+		{"op": "test", "path": "/c", "value": "d"},
+		{"op": "add", "path": "/a/b/1", "value": 5},
+		{"op": "add", "path": "/a/b/-", "value": 6},
+		{"op": "move", "from": "/c", "path": "/e"},
+		{"op": "copy", "from": "/a/b", "path": "/f"},
+		{"op": "remove", "path": "/f/0"},
+		{"op": "replace", "path": "/a/b/0", "value": {"g": null}}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := patch.Apply(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"a": {"b": [{"g": null}, 5, 2, 6]}, "e": "d", "f": [5, 2, 6]}`
+	if result.JSON(false) != expected {
+		t.Fatalf("Unexpected result.  `%v` != `%v`", result.JSON(false), expected)
+	}
+
+	failing, err := DecodePatch(mustDecode(t, `[
+		{"op": "remove", "path": "/c"},
+		{"op": "test", "path": "/a/b/0", "value": 2}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := failing.Apply(doc); err == nil {
+		t.Fatal("Expected the test op to fail")
+	}
+	if _, ok := doc.(Object)["c"]; !ok {
+		t.Fatal("Failed patch changed the document")
+	}
+}
@@ -64,6 +64,7 @@ func NewString(val string) *String { return &String{val: val} }
 func NewArray() Array              { return nil }
 func NewObject() Object            { return make(Object) }
 func NewNumber(val int64) *Number  { num := &Number{}; num.SetInt64(val); return num }
+func NewFloat(val float64) *Number { num := &Number{}; num.SetFloat64(val); return num }
 
 func (v *String) Type() ValueType   { return StringValue }
 func (v *String) Set(val string)    { v.val = val }
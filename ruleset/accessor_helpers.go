@@ -1,5 +1,12 @@
 package ruleset
 
+import (
+	"time"
+
+	"github.com/rollerderby/go/json"
+	"github.com/rollerderby/go/state"
+)
+
 func (rs *Ruleset) NewRule(Name, Type, DefaultValue string, EnumValues []string) (*Rule, error) {
 	r, err := rs.Rules().New(Name)
 	if err != nil {
@@ -74,3 +81,18 @@ func (h *Ruleset) init() error {
 
 	return nil
 }
+
+// Duration parses the value of a "Time" rule, see state.ParseDuration
+func (r *Rule) Duration() (time.Duration, error) {
+	return state.ParseDuration(r.Value())
+}
+
+// effectiveValue computes Rule.EffectiveValue, the Value or, if that is
+// empty, the DefaultValue
+func effectiveValue(parent state.Value) json.Value {
+	r := newRule(parent.(*state.Object))
+	if r.Value() != "" {
+		return json.NewString(r.Value())
+	}
+	return json.NewString(r.DefaultValue())
+}
@@ -0,0 +1,37 @@
+package ruleset
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rollerderby/go/state"
+)
+
+func TestRuleDuration(t *testing.T) {
+	tests := []struct {
+		val      string
+		expected time.Duration
+		ok       bool
+	}{
+		{"2:00", 2 * time.Minute, true},
+		{"60:00", time.Hour, true},
+		{"0:00", 0, true},
+		{"-0:05.5", -5500 * time.Millisecond, true},
+		{"1:75", 0, false},
+		{"", 0, false},
+		{"Count Up", 0, false},
+	}
+	r := newRule(new_state_Rule().(*state.Object))
+	for _, test := range tests {
+		if err := r.SetValue(test.val); err != nil {
+			t.Fatal(err)
+		}
+		d, err := r.Duration()
+		if (err == nil) != test.ok {
+			t.Fatalf("%q: unexpected error %v", test.val, err)
+		}
+		if d != test.expected {
+			t.Fatalf("%q: expected %v, got %v", test.val, test.expected, d)
+		}
+	}
+}
@@ -2,9 +2,12 @@ package server
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/rollerderby/go/auth"
+	"github.com/rollerderby/go/json"
 	"github.com/rollerderby/go/logger"
+	"github.com/rollerderby/go/state"
 	"github.com/rollerderby/go/websocket"
 )
 
@@ -15,7 +18,8 @@ type controlConnection struct {
 
 func controlHandler(w http.ResponseWriter, r *http.Request) {
 	var err error
-	c := &controlConnection{user: auth.CheckAuth(r)}
+	c := &controlConnection{}
+	state.Root.View(func() { c.user = auth.CheckAuth(r) })
 
 	if c.ws, err = websocket.New(c, w, r); err != nil {
 		log.Errf("Cannot make websocket: %v", err)
@@ -25,6 +29,11 @@ func controlHandler(w http.ResponseWriter, r *http.Request) {
 	c.menuItems(nil)
 
 	c.ws.Register("MenuItems", c.menuItems)
+	c.ws.Register("Backups", c.backups)
+	c.ws.Register("CreateBackup", c.createBackup)
+	c.ws.Register("RestoreBackup", c.restoreBackup)
+	c.ws.Register("Audit", c.audit)
+	c.ws.SyncState()
 	c.ws.Loop()
 }
 
@@ -32,6 +41,9 @@ func (c *controlConnection) Close(err error) {
 }
 
 func (c *controlConnection) User() websocket.User {
+	if c.user == nil {
+		return nil
+	}
 	return c.user
 }
 
@@ -44,6 +56,93 @@ func (c *controlConnection) Log() *logger.Logger {
 }
 
 func (c *controlConnection) menuItems(msg *websocket.Message) error {
-	c.ws.SendResponse("MenuItems", auth.MenuItems(c.user))
+	state.Root.RLock()
+	items := auth.MenuItems(c.user)
+	state.Root.RUnlock()
+
+	c.ws.SendResponse("MenuItems", items)
 	return nil
 }
+
+func (c *controlConnection) isAdmin() bool {
+	if c.user == nil {
+		return false
+	}
+	state.Root.RLock()
+	defer state.Root.RUnlock()
+	return c.user.HasGroup("admin")
+}
+
+func (c *controlConnection) backups(msg *websocket.Message) error {
+	if !c.isAdmin() {
+		return c.ws.SendError("Not allowed", nil)
+	}
+	backups, err := state.Root.Backups()
+	if err != nil {
+		return c.ws.SendError("Cannot list backups", err)
+	}
+	return c.ws.SendResponse("Backups", backups)
+}
+
+func (c *controlConnection) createBackup(msg *websocket.Message) error {
+	if !c.isAdmin() {
+		return c.ws.SendError("Not allowed", nil)
+	}
+	if _, err := state.Root.Backup(); err != nil {
+		return c.ws.SendError("Cannot create backup", err)
+	}
+	return c.backups(msg)
+}
+
+func (c *controlConnection) restoreBackup(msg *websocket.Message) error {
+	if !c.isAdmin() {
+		return c.ws.SendError("Not allowed", nil)
+	}
+	name, ok := msg.Data.(*json.String)
+	if !ok {
+		return c.ws.SendError("Backup name must be a string", nil)
+	}
+	if err := state.Root.RestoreBackup(name.Get()); err != nil {
+		log.Errorf("Cannot restore backup %q: %v", name, err)
+		return c.ws.SendError("Cannot restore backup", err)
+	}
+	var username string
+	state.Root.View(func() { username = c.user.Name() })
+	log.Noticef("Restored backup %q for %v", name, username)
+	return c.backups(msg)
+}
+
+// audit answers {"Path": ..., "User": ..., "Since": ..., "Until": ...} with
+// the matching audit log entries.  Every field is optional, times are
+// RFC3339.
+func (c *controlConnection) audit(msg *websocket.Message) error {
+	if !c.isAdmin() {
+		return c.ws.SendError("Not allowed", nil)
+	}
+	obj, _ := msg.Data.(json.Object)
+
+	var q state.AuditQuery
+	if str, ok := obj["Path"].(*json.String); ok {
+		q.Path = str.Get()
+	}
+	if str, ok := obj["User"].(*json.String); ok {
+		q.User = str.Get()
+	}
+	for key, dest := range map[string]*time.Time{"Since": &q.Since, "Until": &q.Until} {
+		str, ok := obj[key].(*json.String)
+		if !ok {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, str.Get())
+		if err != nil {
+			return c.ws.SendError("Invalid "+key, err)
+		}
+		*dest = t
+	}
+
+	entries, err := state.Root.Audit(q)
+	if err != nil {
+		return c.ws.SendError("Cannot read audit log", err)
+	}
+	return c.ws.SendResponse("Audit", entries)
+}
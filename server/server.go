@@ -44,6 +44,7 @@ func setVersion() {
 
 	versionStr := state.NewString().(*state.String)
 	versionStr.SetValue(version)
+	versionStr.AddWriteGroup("admin")
 
 	state.Root.Add("Version", "", versionStr)
 }
@@ -92,6 +93,10 @@ func Run(port uint16) bool {
 	signal.Notify(signals, os.Interrupt, os.Kill, restart)
 	s := <-signals
 
+	if err := state.Root.Close(); err != nil {
+		log.Errorf("Cannot save config before exiting: %v", err)
+	}
+
 	if s, ok := s.(*RestartSignal); ok {
 		log.Alertf("Restarting server: %+v", s)
 		if s.ResetConfig {
@@ -39,22 +39,130 @@ func (obj *Array) NewElement(j json.Value) (Value, error) {
 		if err := elem.SetJSON(j); err != nil {
 			return nil, err
 		}
+		if err := validate(elem); err != nil {
+			return nil, err
+		}
+	}
+
+	err := atomic(obj, func() error {
+		changingValue(obj)
+		if obj.parent != nil {
+			elem.SetParentAndPath(obj, fmt.Sprintf("%v[%v]", obj.path, len(obj.values)))
+		}
+		obj.values = append(obj.values, elem)
+		changedValue(obj)
+
+		// References in elem were set before it had a store to check them with
+		if s := storeOf(elem); s != nil {
+			return s.checkReferences(elem)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return elem, nil
+}
+
+// Insert adds a new element at idx, moving the elements after it up one
+func (obj *Array) Insert(idx int, j json.Value) (Value, error) {
+	if idx < 0 || idx > len(obj.values) {
+		return nil, errInvalidIndex(idx)
+	}
+	if obj.initializer == nil {
+		return nil, errNoInitializer
 	}
 
-	if obj.parent != nil {
-		elem.SetParentAndPath(obj, fmt.Sprintf("%v[%v]", obj.path, len(obj.values)))
+	elem := obj.initializer()
+	if j != nil {
+		if err := elem.SetJSON(j); err != nil {
+			return nil, err
+		}
+		if err := validate(elem); err != nil {
+			return nil, err
+		}
+	}
+
+	err := atomic(obj, func() error {
+		changingValue(obj)
+		obj.values = append(obj.values, nil)
+		copy(obj.values[idx+1:], obj.values[idx:])
+		obj.values[idx] = elem
+		obj.reindex(idx)
+		changedValue(obj)
+
+		// References in elem were set before it had a store to check them with
+		if s := storeOf(elem); s != nil {
+			return s.checkReferences(elem)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	obj.values = append(obj.values, elem)
-	Root.changedValue(obj)
 	return elem, nil
 }
 
+// Remove deletes the element at idx, moving the elements after it down one
+func (obj *Array) Remove(idx int) error {
+	if idx < 0 || idx >= len(obj.values) {
+		return errInvalidIndex(idx)
+	}
+
+	changingValue(obj)
+	obj.values[idx].SetParentAndPath(nil, "")
+	obj.values = append(obj.values[:idx], obj.values[idx+1:]...)
+	obj.reindex(idx)
+	changedValue(obj)
+	return nil
+}
+
+// Move moves the element at from to to, shifting the ones in between
+func (obj *Array) Move(from, to int) error {
+	if from < 0 || from >= len(obj.values) {
+		return errInvalidIndex(from)
+	}
+	if to < 0 || to >= len(obj.values) {
+		return errInvalidIndex(to)
+	}
+	if from == to {
+		return nil
+	}
+
+	changingValue(obj)
+	elem := obj.values[from]
+	if from < to {
+		copy(obj.values[from:to], obj.values[from+1:to+1])
+	} else {
+		copy(obj.values[to+1:from+1], obj.values[to:from])
+	}
+	obj.values[to] = elem
+	if from < to {
+		obj.reindex(from)
+	} else {
+		obj.reindex(to)
+	}
+	changedValue(obj)
+	return nil
+}
+
+// reindex fixes the paths of the elements from idx on
+func (obj *Array) reindex(idx int) {
+	if obj.parent == nil {
+		return
+	}
+	for ; idx < len(obj.values); idx++ {
+		obj.values[idx].SetParentAndPath(obj, fmt.Sprintf("%v[%v]", obj.path, idx))
+	}
+}
+
 func (obj *Array) Clear() {
+	changingValue(obj)
 	for _, value := range obj.values {
 		value.SetParentAndPath(nil, "")
 	}
 	obj.values = nil
-	Root.changedValue(obj)
+	changedValue(obj)
 }
 
 func (obj *Array) Values() []Value {
@@ -68,7 +176,6 @@ func (obj *Array) WriteGroups() []string {
 }
 func (obj *Array) AddWriteGroup(group ...string) {
 	obj.writeGroups = mergeGroups(obj.writeGroups, group)
-	obj.readGroups = mergeGroups(obj.readGroups, group)
 }
 func (obj *Array) ReadGroups() []string {
 	return obj.readGroups
@@ -104,7 +211,24 @@ func (obj *Array) SetParentAndPath(parent Value, path string) {
 		}
 	}
 
-	Root.changedValue(obj)
+	changedValue(obj)
+}
+
+func (obj *Array) snapshot() func() {
+	values := obj.Values()
+	revision := obj.revision
+	return func() {
+		for _, value := range obj.values {
+			value.SetParentAndPath(nil, "")
+		}
+		obj.values = values
+		obj.revision = revision
+		for idx, value := range obj.values {
+			if obj.parent != nil {
+				value.SetParentAndPath(obj, fmt.Sprintf("%v[%v]", obj.path, idx))
+			}
+		}
+	}
 }
 
 func (obj *Array) JSON(skipSave bool) json.Value {
@@ -123,12 +247,14 @@ func (obj *Array) SetJSON(j json.Value) error {
 		return errInvalidJSONType(j, json.ArrayValue)
 	}
 
-	obj.Clear()
-	for _, jValue := range jArray {
-		if _, err := obj.NewElement(jValue); err != nil {
-			return err
+	return atomic(obj, func() error {
+		obj.Clear()
+		for _, jValue := range jArray {
+			if _, err := obj.NewElement(jValue); err != nil {
+				return err
+			}
 		}
-	}
-	Root.changedValue(obj)
-	return nil
+		changedValue(obj)
+		return nil
+	})
 }
@@ -0,0 +1,157 @@
+package state
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/rollerderby/go/json"
+)
+
+func newListObject() Value {
+	return &Object{Definition: ObjectDef{Name: "Listed", Values: []ObjectValueDef{
+		{Name: "ID", Initializer: NewGUID},
+		{Name: "List", Initializer: NewArrayOf(NewString)},
+	}}}
+}
+
+// newList adds an element holding a list of a, b, c and d to a new store
+func newList(t *testing.T, dir string) (*Store, *Array) {
+	s := NewStore(dir)
+	h := NewHashOf(newListObject)().(*Hash)
+	s.Lock()
+	s.Add("Lists", "lists", h)
+	s.Unlock()
+	if err := s.LoadSavedConfigs(); err != nil {
+		t.Fatal(err)
+	}
+	s.SetIsReady(true)
+
+	s.Lock()
+	defer s.Unlock()
+	if keys := h.Keys(); len(keys) > 0 {
+		return s, h.Get(keys[0]).(*Object).Get("List").(*Array)
+	}
+	elem, _ := h.NewEmptyElement("")
+	list := elem.(*Object).Get("List").(*Array)
+	for _, v := range []string{"a", "b", "c", "d"} {
+		list.NewElement(json.NewString(v))
+	}
+	return s, list
+}
+
+// checkList fails unless list holds want with every path matching its index
+func checkList(t *testing.T, list *Array, want string) {
+	t.Helper()
+	if got := list.JSON(false).JSON(false); got != want {
+		t.Fatalf("Expected %v, got %v", want, got)
+	}
+	for idx, value := range list.Values() {
+		if p := fmt.Sprintf("%v[%v]", list.Path(), idx); value.Path() != p {
+			t.Fatalf("Element %v has path %v, expected %v", idx, value.Path(), p)
+		}
+	}
+}
+
+func TestArrayInsertRemoveMove(t *testing.T) {
+	s, list := newList(t, t.TempDir())
+	s.Lock()
+	defer s.Unlock()
+
+	if _, err := list.Insert(1, json.NewString("x")); err != nil {
+		t.Fatal(err)
+	}
+	checkList(t, list, `["a", "x", "b", "c", "d"]`)
+	if err := list.Remove(0); err != nil {
+		t.Fatal(err)
+	}
+	checkList(t, list, `["x", "b", "c", "d"]`)
+	if err := list.Move(0, 3); err != nil {
+		t.Fatal(err)
+	}
+	checkList(t, list, `["b", "c", "d", "x"]`)
+	if err := list.Move(3, 1); err != nil {
+		t.Fatal(err)
+	}
+	checkList(t, list, `["b", "x", "c", "d"]`)
+
+	for name, err := range map[string]error{
+		"Insert":    func() error { _, err := list.Insert(5, json.NewString("y")); return err }(),
+		"Remove":    list.Remove(4),
+		"Move from": list.Move(-1, 0),
+		"Move to":   list.Move(0, 4),
+	} {
+		if _, ok := err.(ErrInvalidIndex); !ok {
+			t.Errorf("%v: expected ErrInvalidIndex, got %v", name, err)
+		}
+	}
+	checkList(t, list, `["b", "x", "c", "d"]`)
+}
+
+func TestArrayMoveUndo(t *testing.T) {
+	s, list := newList(t, t.TempDir())
+	s.Lock()
+	list.Move(0, 2)
+	s.Unlock()
+
+	s.Lock()
+	defer s.Unlock()
+	checkList(t, list, `["b", "c", "a", "d"]`)
+	if _, err := s.Undo(); err != nil {
+		t.Fatal(err)
+	}
+	checkList(t, list, `["a", "b", "c", "d"]`)
+	if _, err := s.Redo(); err != nil {
+		t.Fatal(err)
+	}
+	checkList(t, list, `["b", "c", "a", "d"]`)
+}
+
+func TestArrayMoveJournal(t *testing.T) {
+	dir := t.TempDir()
+	s, list := newList(t, dir)
+	s.Flush()
+	s.Lock()
+	list.Move(3, 0)
+	s.Unlock()
+
+	// Crash without saving the move
+	s.saveMu.Lock()
+	s.Lock()
+	s.closeStorage()
+	s.journal.Close()
+	s.Unlock()
+	s.saveMu.Unlock()
+
+	s, list = newList(t, dir)
+	s.RLock()
+	defer s.RUnlock()
+	checkList(t, list, `["d", "a", "b", "c"]`)
+}
+
+func TestHashDeleteRemovesFile(t *testing.T) {
+	s, h := newSavedStore(t)
+	s.Lock()
+	elem, _ := h.NewEmptyElement("")
+	s.Unlock()
+	s.Flush()
+	key := elem.(*Object).Get("ID").(*GUID).Value()
+	filename := path.Join(s.configDir(), "things", key+".json")
+	if _, err := os.Stat(filename); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Lock()
+	if err := h.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Delete(key); err == nil {
+		t.Fatal("Deleted a missing key")
+	}
+	s.Unlock()
+	s.Flush()
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Fatalf("Config file of the deleted element was kept: %v", err)
+	}
+}
@@ -0,0 +1,318 @@
+package state
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"github.com/rollerderby/go/json"
+)
+
+// The audit log records every committed change, one line per changed path:
+//
+//	{"Time": "...", "Revision": 12, "User": "...", "RemoteAddr": "...", "Path": "...", "Old": ..., "New": ...}
+//
+// It is kept in audit/audit.json.  Once that is bigger than auditMaxSize it
+// is rolled to audit.json.1, pushing the older ones up, keeping auditFiles in
+// all.  The changes are the same ones the history records, see history.go,
+// but without values marked Secret in their ObjectValueDef.
+
+const auditFilename = "audit.json"
+
+// AuditEntry is one change in the audit log.  Old or New is nil if the path
+// did not exist.
+type AuditEntry struct {
+	Time       time.Time
+	Revision   uint64
+	User       string
+	RemoteAddr string
+	Path       string
+	Old        json.Value
+	New        json.Value
+}
+
+type AuditEntries []*AuditEntry
+
+func (a AuditEntries) JSON() json.Value {
+	arr := make(json.Array, 0, len(a))
+	for _, e := range a {
+		arr = append(arr, e.JSON())
+	}
+	return arr
+}
+
+func (e *AuditEntry) JSON() json.Value {
+	rev := &json.Number{}
+	rev.SetUint64(e.Revision)
+
+	obj := make(json.Object)
+	obj["Time"] = json.NewString(e.Time.Format(time.RFC3339Nano))
+	obj["Revision"] = rev
+	obj["User"] = json.NewString(e.User)
+	obj["RemoteAddr"] = json.NewString(e.RemoteAddr)
+	obj["Path"] = json.NewString(e.Path)
+	if e.Old != nil {
+		obj["Old"] = e.Old
+	}
+	if e.New != nil {
+		obj["New"] = e.New
+	}
+	return obj
+}
+
+func decodeAuditEntry(line []byte) (*AuditEntry, error) {
+	jValue, err := json.Decode(line)
+	if err != nil {
+		return nil, err
+	}
+	obj, ok := jValue.(json.Object)
+	if !ok {
+		return nil, errInvalidJSONType(jValue, json.ObjectValue)
+	}
+
+	e := &AuditEntry{Old: obj["Old"], New: obj["New"]}
+	for key, dest := range map[string]*string{"User": &e.User, "RemoteAddr": &e.RemoteAddr, "Path": &e.Path} {
+		if str, ok := obj[key].(*json.String); ok {
+			*dest = str.Get()
+		}
+	}
+	if num, ok := obj["Revision"].(*json.Number); ok {
+		if e.Revision, err = num.GetUint64(); err != nil {
+			return nil, err
+		}
+	}
+	str, ok := obj["Time"].(*json.String)
+	if !ok {
+		return nil, errInvalidJSONValue(obj, errNoKey)
+	}
+	if e.Time, err = time.Parse(time.RFC3339Nano, str.Get()); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// AuditQuery picks entries from the audit log.  Empty fields match
+// everything.
+type AuditQuery struct {
+	Path  string // Changes to Path, anything below it, or a container holding it
+	User  string
+	Since time.Time
+	Until time.Time
+}
+
+func (q AuditQuery) matches(e *AuditEntry) bool {
+	if q.Path != "" && !PathHasPrefix(e.Path, q.Path) && !PathHasPrefix(q.Path, e.Path) {
+		return false
+	}
+	if q.User != "" && e.User != q.User {
+		return false
+	}
+	if !q.Since.IsZero() && e.Time.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && e.Time.After(q.Until) {
+		return false
+	}
+	return true
+}
+
+// SetAuditPolicy sets how many audit files are kept and how big each may get
+// before it is rolled.  A count of 0 turns the audit log off.
+func (r *Store) SetAuditPolicy(count int, maxSize int64) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.closeAudit()
+	r.auditCount = count
+	r.auditMaxSize = maxSize
+}
+
+// SetRemoteAddr records where the changes in the current lock come from.  It
+// is cleared by Unlock.
+func (r *Store) SetRemoteAddr(addr string) {
+	r.remoteAddr = addr
+}
+
+func (r *Store) auditing() bool {
+	return r.auditCount > 0
+}
+
+func (r *Store) auditDir() string {
+	return path.Join(r.basePath, "audit")
+}
+
+// auditFile returns the name of the nth audit file, 0 being the current one
+func (r *Store) auditFile(n int) string {
+	if n == 0 {
+		return path.Join(r.auditDir(), auditFilename)
+	}
+	return path.Join(r.auditDir(), fmt.Sprintf("%v.%v", auditFilename, n))
+}
+
+func (r *Store) openAudit() error {
+	if err := os.MkdirAll(r.auditDir(), 0775); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(r.auditFile(0), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0664)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.audit = f
+	r.auditSize = fi.Size()
+	return nil
+}
+
+func (r *Store) closeAudit() {
+	if r.audit == nil {
+		return
+	}
+	if err := r.audit.Close(); err != nil {
+		log.Errorf("Cannot close audit log: %v", err)
+	}
+	r.audit = nil
+}
+
+// rollAudit moves each audit file up one, dropping the oldest
+func (r *Store) rollAudit() error {
+	r.closeAudit()
+	if err := os.Remove(r.auditFile(r.auditCount - 1)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for n := r.auditCount - 2; n >= 0; n-- {
+		if err := os.Rename(r.auditFile(n), r.auditFile(n+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return r.openAudit()
+}
+
+// writeAudit adds the changes in cs to the audit log.  If undo is set cs is
+// being undone, so Old and New are swapped.
+func (r *Store) writeAudit(cs *ChangeSet, remoteAddr string, undo bool) {
+	if !r.auditing() || len(cs.Changes) == 0 {
+		return
+	}
+	if r.audit == nil {
+		if err := r.openAudit(); err != nil {
+			log.Errorf("Cannot open audit log: %v", err)
+			return
+		}
+	}
+
+	var buf bytes.Buffer
+	for _, c := range cs.Changes {
+		e := &AuditEntry{
+			Time:       cs.Time,
+			Revision:   cs.Revision,
+			User:       cs.User,
+			RemoteAddr: remoteAddr,
+			Path:       c.Path,
+			Old:        c.Old,
+			New:        c.New,
+		}
+		if undo {
+			e.Old, e.New = e.New, e.Old
+		}
+		if !r.stripSecrets(e) {
+			continue
+		}
+		buf.WriteString(e.JSON().JSON(false))
+		buf.WriteByte('\n')
+	}
+
+	if r.auditSize > 0 && r.auditMaxSize > 0 && r.auditSize+int64(buf.Len()) > r.auditMaxSize {
+		if err := r.rollAudit(); err != nil {
+			log.Errorf("Cannot roll audit log: %v", err)
+			return
+		}
+	}
+	if _, err := r.audit.Write(buf.Bytes()); err != nil {
+		log.Errorf("Cannot write audit log: %v", err)
+		return
+	}
+	r.auditSize += int64(buf.Len())
+}
+
+// Audit returns the entries in the audit log matching q, oldest first
+func (r *Store) Audit(q AuditQuery) (AuditEntries, error) {
+	r.RLock()
+	defer r.RUnlock()
+
+	var ret AuditEntries
+	for n := r.auditCount - 1; n >= 0; n-- {
+		entries, err := r.readAudit(r.auditFile(n), q)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, entries...)
+	}
+	return ret, nil
+}
+
+func (r *Store) readAudit(filename string, q AuditQuery) (AuditEntries, error) {
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var ret AuditEntries
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		e, err := decodeAuditEntry(line)
+		if err != nil {
+			log.Errorf("Skipping unreadable entry in %v: %v", filename, err)
+			continue
+		}
+		if q.matches(e) && r.stripSecrets(e) {
+			ret = append(ret, e)
+		}
+	}
+	return ret, scanner.Err()
+}
+
+// stripSecrets removes Secret values from e, returning false if e changed
+// nothing but secrets.  Entries logged before a value was marked Secret are
+// stripped when read too.
+func (r *Store) stripSecrets(e *AuditEntry) bool {
+	keys, err := SplitPath(e.Path)
+	if err != nil {
+		return true
+	}
+	var value Value = r
+	for _, key := range keys {
+		if obj, ok := value.(*Object); ok && obj.isSecret(key) {
+			return false
+		}
+		if value = childOrNew(value, key); value == nil {
+			return true
+		}
+	}
+
+	keep := func(parent Value, key string, child Value) bool {
+		obj, ok := parent.(*Object)
+		return !ok || !obj.isSecret(key)
+	}
+	if e.Old != nil {
+		e.Old = filterSnapshot(value, e.Old, keep)
+	}
+	if e.New != nil {
+		e.New = filterSnapshot(value, e.New, keep)
+	}
+	return true
+}
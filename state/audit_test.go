@@ -0,0 +1,44 @@
+package state
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAuditSecrets(t *testing.T) {
+	s := NewStore(t.TempDir())
+	h := NewHashOf(func() Value {
+		return &Object{Definition: ObjectDef{Name: "User", Values: []ObjectValueDef{
+			{Name: "ID", Initializer: NewGUID},
+			{Name: "Name", Initializer: NewString},
+			{Name: "PasswordHash", Initializer: NewString, Secret: true},
+		}}}
+	})().(*Hash)
+	s.Lock()
+	s.Add("Users", "", h)
+	s.isReady = true
+	s.Unlock()
+	s.SetAuditPolicy(1, 0)
+
+	s.Lock()
+	elem, _ := h.NewEmptyElement("")
+	elem.(*Object).Get("PasswordHash").(*String).SetValue("first")
+	s.Unlock()
+	s.Lock()
+	elem.(*Object).Get("Name").(*String).SetValue("a")
+	elem.(*Object).Get("PasswordHash").(*String).SetValue("second")
+	s.Unlock()
+
+	entries, err := s.Audit(AuditQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %v", entries.JSON().JSON(false))
+	}
+	for _, e := range entries {
+		if j := e.JSON().JSON(false); strings.Contains(j, "PasswordHash") || strings.Contains(j, "first") || strings.Contains(j, "second") {
+			t.Fatalf("Secret in audit entry %v", j)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rollerderby/go/json"
+)
+
+// Backups are copies of the config folder in backups/config-<time>.  SaveLoop
+// makes one when it starts, and after saving changes once backupInterval has
+// passed since the last one, keeping the newest backupCount.
+
+const (
+	backupPrefix     = "config-"
+	backupTimeLayout = "20060102-150405.000"
+)
+
+var errInvalidBackup = errors.New("Invalid backup name")
+
+type BackupInfo struct {
+	Name string
+	Time time.Time
+}
+
+type Backups []*BackupInfo
+
+func (b Backups) JSON() json.Value {
+	arr := make(json.Array, 0, len(b))
+	for _, bi := range b {
+		arr = append(arr, bi.JSON())
+	}
+	return arr
+}
+
+func (bi *BackupInfo) JSON() json.Value {
+	obj := make(json.Object)
+	obj["Name"] = json.NewString(bi.Name)
+	obj["Time"] = json.NewString(bi.Time.Format(time.RFC3339))
+	return obj
+}
+
+// SetBackupPolicy sets how many automatic backups are kept and how often one
+// is made.  A count or interval of 0 turns automatic backups off.
+func (r *Store) SetBackupPolicy(count int, interval time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.backupCount = count
+	r.backupInterval = interval
+}
+
+func (r *Store) backupDir() string {
+	return path.Join(r.basePath, "backups")
+}
+
+// backupIfDue is called after saving, with saveMu and the lock held
+func (r *Store) backupIfDue() {
+	if r.backupCount <= 0 || r.backupInterval <= 0 || time.Since(r.lastBackup) < r.backupInterval {
+		return
+	}
+	if _, err := r.backup(); err != nil {
+		log.Errorf("Cannot backup config: %v", err)
+	}
+}
+
+// Backup saves all changes and copies the config folder to a new backup
+func (r *Store) Backup() (*BackupInfo, error) {
+	r.saveMu.Lock()
+	defer r.saveMu.Unlock()
+	r.Lock()
+	defer r.Unlock()
+
+	if !r.saveAllNeeded() {
+		return nil, errors.New("Cannot save all changes before backup")
+	}
+	return r.backup()
+}
+
+func (r *Store) backup() (*BackupInfo, error) {
+	now := time.Now()
+	r.lastBackup = now
+
+	// Names only go down to the millisecond, so make sure this one is new
+	name := backupPrefix + now.Format(backupTimeLayout)
+	for {
+		if _, err := os.Stat(path.Join(r.backupDir(), name)); os.IsNotExist(err) {
+			break
+		}
+		now = now.Add(time.Millisecond)
+		name = backupPrefix + now.Format(backupTimeLayout)
+	}
+	src := r.configDir()
+	dst := path.Join(r.backupDir(), name)
+	if _, err := os.Stat(src); os.IsNotExist(err) {
+		// Nothing saved yet
+		return nil, nil
+	}
+
+	err := filepath.Walk(src, func(filename string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, filename)
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return os.MkdirAll(filepath.Join(dst, rel), 0775)
+		}
+		if filename == r.journalFilename() || strings.HasPrefix(fi.Name(), ".") {
+			return nil
+		}
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(filepath.Join(dst, rel), data, 0664)
+	})
+	if err != nil {
+		os.RemoveAll(dst)
+		return nil, err
+	}
+	log.Infof("Backed up config to %q", dst)
+
+	r.pruneBackups()
+	return &BackupInfo{Name: name, Time: now}, nil
+}
+
+func (r *Store) pruneBackups() {
+	if r.backupCount <= 0 {
+		return
+	}
+	backups, err := r.backups()
+	if err != nil {
+		log.Errorf("Cannot list backups: %v", err)
+		return
+	}
+	for len(backups) > r.backupCount {
+		dir := path.Join(r.backupDir(), backups[0].Name)
+		if err := os.RemoveAll(dir); err != nil {
+			log.Errorf("Cannot remove old backup %q: %v", dir, err)
+		}
+		backups = backups[1:]
+	}
+}
+
+// Backups lists the available backups, oldest first
+func (r *Store) Backups() (Backups, error) {
+	r.RLock()
+	defer r.RUnlock()
+	return r.backups()
+}
+
+func (r *Store) backups() (Backups, error) {
+	files, err := ioutil.ReadDir(r.backupDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var ret Backups
+	for _, fi := range files {
+		if !fi.IsDir() || !strings.HasPrefix(fi.Name(), backupPrefix) {
+			continue
+		}
+		t, err := time.ParseInLocation(backupTimeLayout, strings.TrimPrefix(fi.Name(), backupPrefix), time.Local)
+		if err != nil {
+			continue
+		}
+		ret = append(ret, &BackupInfo{Name: fi.Name(), Time: t})
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Time.Before(ret[j].Time) })
+	return ret, nil
+}
+
+// RestoreBackup replaces every saved root with the contents of the named
+// backup.  The current config is backed up first, and if any file in the
+// backup fails to load nothing is changed.  The roots may refer to each other,
+// so references are only checked once all of them are loaded.
+func (r *Store) RestoreBackup(name string) error {
+	if name != path.Base(name) || !strings.HasPrefix(name, backupPrefix) {
+		return errInvalidBackup
+	}
+	dir := path.Join(r.backupDir(), name)
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		return errInvalidBackup
+	}
+
+	r.saveMu.Lock()
+	defer r.saveMu.Unlock()
+	r.Lock()
+	defer r.Unlock()
+
+	if !r.saveAllNeeded() {
+		return errors.New("Cannot save all changes before restore")
+	}
+	if _, err := r.backup(); err != nil {
+		return fmt.Errorf("Cannot backup current config: %v", err)
+	}
+
+	storage, err := r.storageOpener()(dir, true)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	err = r.Transaction(func() error {
+		r.deferRefs = true
+		defer func() { r.deferRefs = false }()
+		for valueName, value := range r.values {
+			if value.backingFile == "" {
+				continue
+			}
+			if hash, ok := value.value.(*Hash); ok {
+				jValues, errs := storage.LoadHash(value.backingFile)
+				if len(errs) > 0 && !os.IsNotExist(errs[0]) {
+					return errs[0]
+				}
+				if err := hash.Clear(); err != nil {
+					return err
+				}
+				for key, json := range jValues {
+					json, _, err := r.migrate(valueName, key, json, nil)
+					if err != nil {
+						return err
+					}
+					if _, err := hash.NewElement(key, json); err != nil {
+						return fmt.Errorf("%v[%v]: %v", valueName, key, err)
+					}
+				}
+				for _, elem := range hash.Values() {
+					elem.SetSaveNeeded(true)
+				}
+			} else {
+				json, err := storage.Load(value.backingFile)
+				if os.IsNotExist(err) {
+					continue
+				} else if err != nil {
+					return err
+				}
+				json, _, err = r.migrate(valueName, "", json, nil)
+				if err != nil {
+					return err
+				}
+				if err := value.value.SetJSON(json); err != nil {
+					return fmt.Errorf("%v: %v", valueName, err)
+				}
+				value.value.SetSaveNeeded(true)
+			}
+		}
+		r.deferRefs = false
+		return r.checkAllReferences()
+	})
+	if err != nil {
+		return err
+	}
+
+	// Also removes the config files of keys not in the backup
+	r.saveAllNeeded()
+	log.Noticef("Restored config from backup %q", name)
+	return nil
+}
@@ -0,0 +1,162 @@
+package state
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newSavedStore is newTestStore with Things saved in config/things
+func newSavedStore(t *testing.T) (*Store, *Hash) {
+	s := NewStore(t.TempDir())
+	h := NewHashOf(newSecretObject)().(*Hash)
+	s.Lock()
+	s.Add("Things", "things", h)
+	s.isReady = true
+	s.Unlock()
+	t.Cleanup(func() { s.Close() })
+	return s, h
+}
+
+func TestBackupRestore(t *testing.T) {
+	s, h := newSavedStore(t)
+	s.Lock()
+	elem, _ := h.NewEmptyElement("")
+	name := elem.(*Object).Get("Name").(*String)
+	name.SetValue("before")
+	s.Unlock()
+	key := elem.(*Object).Get("ID").(*GUID).Value()
+
+	info, err := s.Backup()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.Lock()
+	name.SetValue("after")
+	added, _ := h.NewEmptyElement("")
+	s.Unlock()
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	addedFile := path.Join(s.configDir(), "things", added.(*Object).Get("ID").(*GUID).Value()+".json")
+	if _, err := os.Stat(addedFile); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.RestoreBackup(info.Name); err != nil {
+		t.Fatal(err)
+	}
+	s.RLock()
+	keys := h.Keys()
+	restored := h.Get(key)
+	s.RUnlock()
+	if len(keys) != 1 || restored == nil || restored.(*Object).Get("Name").(*String).Value() != "before" {
+		t.Fatalf("Backup not restored: %v", h.JSON(false).JSON(false))
+	}
+	if _, err := os.Stat(addedFile); !os.IsNotExist(err) {
+		t.Fatalf("Config file of a key not in the backup was kept: %v", err)
+	}
+
+	if err := s.RestoreBackup("../" + info.Name); err != errInvalidBackup {
+		t.Fatalf("Expected errInvalidBackup, got %v", err)
+	}
+}
+
+func TestBackupRestoreReferences(t *testing.T) {
+	s, h := newSavedStore(t)
+	refs := NewHashOf(newRefObject)().(*Hash)
+	s.Lock()
+	s.Add("Refs", "refs", refs)
+	target, _ := h.NewEmptyElement("")
+	key := target.(*Object).Get("ID").(*GUID).Value()
+	ref, _ := refs.NewEmptyElement("")
+	ref.(*Object).Get("Thing").(*GUID).SetValue(key)
+	s.Unlock()
+	refKey := ref.(*Object).Get("ID").(*GUID).Value()
+
+	info, err := s.Backup()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The roots load in any order, so do it a few times
+	for i := 0; i < 5; i++ {
+		s.Lock()
+		err := h.Delete(key)
+		s.Unlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if refs.Get(refKey) != nil {
+			t.Fatal("Delete did not cascade")
+		}
+
+		if err := s.RestoreBackup(info.Name); err != nil {
+			t.Fatal(err)
+		}
+		s.RLock()
+		restored := refs.Get(refKey)
+		s.RUnlock()
+		if h.Get(key) == nil || restored == nil || restored.(*Object).Get("Thing").(*GUID).Value() != key {
+			t.Fatalf("Backup not restored: %v %v", h.JSON(false).JSON(false), refs.JSON(false).JSON(false))
+		}
+	}
+
+	// A backup referring to something it does not hold is not restored
+	os.Remove(path.Join(s.backupDir(), info.Name, "things", key+".json"))
+	if err := s.RestoreBackup(info.Name); err == nil {
+		t.Fatal("Restored a backup with a missing reference")
+	}
+	s.RLock()
+	defer s.RUnlock()
+	if h.Get(key) == nil || refs.Get(refKey) == nil {
+		t.Fatal("A failed restore changed the config")
+	}
+}
+
+func TestBackupPrune(t *testing.T) {
+	s, h := newSavedStore(t)
+	s.SetBackupPolicy(2, time.Hour)
+	s.Lock()
+	h.NewEmptyElement("")
+	s.Unlock()
+
+	var names []string
+	for i := 0; i < 3; i++ {
+		info, err := s.Backup()
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, info.Name)
+	}
+	backups, err := s.Backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 || backups[0].Name != names[1] || backups[1].Name != names[2] {
+		t.Fatalf("Expected the newest 2 of %v, got %v", names, backups.JSON().JSON(false))
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	filename := path.Join(dir, "a.json")
+	for _, data := range []string{"first", "second"} {
+		if err := writeFileAtomic(filename, []byte(data), 0664); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if data, _ := ioutil.ReadFile(filename); string(data) != "second" {
+		t.Fatalf("Expected second, got %q", data)
+	}
+	files, _ := ioutil.ReadDir(dir)
+	for _, fi := range files {
+		if strings.HasPrefix(fi.Name(), ".") {
+			t.Fatalf("Temp file %v left behind", fi.Name())
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package state
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"github.com/rollerderby/go/json"
+	bolt "go.etcd.io/bbolt"
+)
+
+// BoltFilename is the database NewBoltStorage keeps in the config folder.
+// Every write happens while saveMu is held, as do backups, so they can copy
+// the file like any other config.
+const BoltFilename = "state.db"
+
+var (
+	boltValues = []byte("Values") // name -> JSON of roots that aren't hashes
+	boltHashes = []byte("Hashes") // name -> bucket of key -> JSON
+)
+
+type boltStorage struct {
+	db *bolt.DB
+}
+
+// NewBoltStorage stores configs in a single database file in dir.  Opened
+// read only, a missing database is treated as empty.
+func NewBoltStorage(dir string, readOnly bool) (Storage, error) {
+	filename := path.Join(dir, BoltFilename)
+	if readOnly {
+		if _, err := os.Stat(filename); os.IsNotExist(err) {
+			return &boltStorage{}, nil
+		}
+		db, err := bolt.Open(filename, 0664, &bolt.Options{Timeout: time.Second, ReadOnly: true})
+		if err != nil {
+			return nil, fmt.Errorf("Cannot open %q: %v", filename, err)
+		}
+		return &boltStorage{db: db}, nil
+	}
+
+	if err := os.MkdirAll(dir, 0775); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(filename, 0664, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("Cannot open %q: %v", filename, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(boltValues); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(boltHashes)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltStorage{db: db}, nil
+}
+
+func (s *boltStorage) Load(name string) (json.Value, error) {
+	var data []byte
+	s.view(func(tx *bolt.Tx) error {
+		if b := tx.Bucket(boltValues); b != nil {
+			if d := b.Get([]byte(name)); d != nil {
+				data = append([]byte(nil), d...)
+			}
+		}
+		return nil
+	})
+	if data == nil {
+		return nil, &os.PathError{Op: "load", Path: name, Err: os.ErrNotExist}
+	}
+	return json.Decode(data)
+}
+
+func (s *boltStorage) LoadHash(name string) (map[string]json.Value, []error) {
+	var errors []error
+	ret := make(map[string]json.Value)
+	s.view(func(tx *bolt.Tx) error {
+		hashes := tx.Bucket(boltHashes)
+		if hashes == nil {
+			return nil
+		}
+		b := hashes.Bucket([]byte(name))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			j, err := json.Decode(v)
+			if err != nil {
+				errors = append(errors, fmt.Errorf("%v[%s]: %v", name, k, err))
+			} else {
+				ret[string(k)] = j
+			}
+			return nil
+		})
+	})
+	return ret, errors
+}
+
+// Save writes all the changes in one transaction, so either all or none of
+// them are saved
+func (s *boltStorage) Save(changes []StorageChange) error {
+	if s.db == nil || s.db.IsReadOnly() {
+		return errReadOnlyStore
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, change := range changes {
+			if change.Key == "" {
+				if err := tx.Bucket(boltValues).Put([]byte(change.Name), []byte(change.Value.JSON(true))); err != nil {
+					return err
+				}
+				continue
+			}
+
+			b, err := tx.Bucket(boltHashes).CreateBucketIfNotExists([]byte(change.Name))
+			if err != nil {
+				return err
+			}
+			if change.Value == nil {
+				err = b.Delete([]byte(change.Key))
+			} else {
+				err = b.Put([]byte(change.Key), []byte(change.Value.JSON(true)))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		log.Infof("Saved %v config value(s) to %q", len(changes), s.db.Path())
+		return nil
+	})
+}
+
+// view runs f unless there is no database, which is only the case for one
+// opened read only
+func (s *boltStorage) view(f func(tx *bolt.Tx) error) error {
+	if s.db == nil {
+		return nil
+	}
+	return s.db.View(f)
+}
+
+func (s *boltStorage) Close() error {
+	if s.db == nil {
+		return nil
+	}
+	return s.db.Close()
+}
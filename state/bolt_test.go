@@ -0,0 +1,113 @@
+package state
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+// newBoltStore is newSavedStore using NewBoltStorage, with a String root
+// saved as title
+func newBoltStore(t *testing.T, dir string) (*Store, *Hash, *String) {
+	s := NewStore(dir)
+	s.SetStorage(NewBoltStorage)
+	h := NewHashOf(newSecretObject)().(*Hash)
+	title := NewString().(*String)
+	s.Lock()
+	s.Add("Things", "things", h)
+	s.Add("Title", "title", title)
+	s.Unlock()
+	if err := s.LoadSavedConfigs(); err != nil {
+		t.Fatal(err)
+	}
+	s.SetIsReady(true)
+	t.Cleanup(func() { s.Close() })
+	return s, h, title
+}
+
+func TestBoltRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	s, h, title := newBoltStore(t, dir)
+	s.Lock()
+	first, _ := h.NewEmptyElement("")
+	first.(*Object).Get("Name").(*String).SetValue("first")
+	second, _ := h.NewEmptyElement("")
+	title.SetValue("bolt")
+	s.Unlock()
+	firstKey := first.(*Object).Get("ID").(*GUID).Value()
+	secondKey := second.(*Object).Get("ID").(*GUID).Value()
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	s.Lock()
+	h.Delete(secondKey)
+	s.Unlock()
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s, h, title = newBoltStore(t, dir)
+	s.RLock()
+	defer s.RUnlock()
+	if keys := h.Keys(); len(keys) != 1 || keys[0] != firstKey {
+		t.Fatalf("Expected only %v, got %v", firstKey, keys)
+	}
+	if name := h.Get(firstKey).(*Object).Get("Name").(*String).Value(); name != "first" {
+		t.Fatalf("Name loaded as %q", name)
+	}
+	if title.Value() != "bolt" {
+		t.Fatalf("Title loaded as %q", title.Value())
+	}
+}
+
+func TestBoltBackupReadOnly(t *testing.T) {
+	s, h, _ := newBoltStore(t, t.TempDir())
+	s.Lock()
+	h.NewEmptyElement("")
+	s.Unlock()
+	info, err := s.Backup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := path.Join(s.backupDir(), info.Name, BoltFilename)
+	before, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.Lock()
+	h.NewEmptyElement("")
+	s.Unlock()
+	if err := s.RestoreBackup(info.Name); err != nil {
+		t.Fatal(err)
+	}
+	s.RLock()
+	n := len(h.Keys())
+	s.RUnlock()
+	if n != 1 {
+		t.Fatalf("Expected 1 element after restore, got %v", n)
+	}
+	if after, err := ioutil.ReadFile(filename); err != nil || !bytes.Equal(before, after) {
+		t.Fatalf("Restore wrote to the backup: %v", err)
+	}
+
+	// Nothing is created where there is no database
+	dir := t.TempDir()
+	storage, err := NewBoltStorage(path.Join(dir, "missing"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+	if _, err := storage.Load("title"); !os.IsNotExist(err) {
+		t.Fatalf("Expected not exist, got %v", err)
+	}
+	if err := storage.Save([]StorageChange{{Name: "title"}}); err == nil {
+		t.Fatal("Saved to a read only storage")
+	}
+	if _, err := os.Stat(path.Join(dir, "missing")); !os.IsNotExist(err) {
+		t.Fatalf("Read only open created the folder: %v", err)
+	}
+}
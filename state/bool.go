@@ -29,8 +29,9 @@ func (obj *Bool) SetValue(val bool) error {
 	if obj.value == val {
 		return nil
 	}
+	changingValue(obj)
 	obj.value = val
-	Root.changedValue(obj)
+	changedValue(obj)
 	return nil
 }
 
@@ -39,7 +40,6 @@ func (obj *Bool) WriteGroups() []string {
 }
 func (obj *Bool) AddWriteGroup(group ...string) {
 	obj.writeGroups = mergeGroups(obj.writeGroups, group)
-	obj.readGroups = mergeGroups(obj.readGroups, group)
 }
 func (obj *Bool) ReadGroups() []string {
 	return obj.readGroups
@@ -60,7 +60,12 @@ func (obj *Bool) String() string         { return fmt.Sprint(obj.value) }
 func (obj *Bool) SetParentAndPath(parent Value, path string) {
 	obj.parent = parent
 	obj.path = path
-	Root.changedValue(obj)
+	changedValue(obj)
+}
+
+func (obj *Bool) snapshot() func() {
+	value, revision := obj.value, obj.revision
+	return func() { obj.value, obj.revision = value, revision }
 }
 
 func (obj *Bool) JSON(skipSave bool) json.Value {
@@ -0,0 +1,156 @@
+package state
+
+import "github.com/rollerderby/go/json"
+
+// Computed is a read only value worked out by compute from other values.
+// compute is given the Computed's parent and may only read values below it.
+// It is rerun at the end of every lock cycle that changed anything below the
+// parent.  Computed values are never saved.
+type Computed struct {
+	compute     func(parent Value) json.Value
+	value       json.Value
+	parent      Value
+	path        string
+	revision    uint64
+	stale       bool // Not computed since it was added
+	writeGroups []string
+	readGroups  []string
+}
+
+// Computed values may depend on each other, but a cycle that never settles
+// is stopped after this many passes
+const maxComputePasses = 10
+
+func NewComputed(compute func(parent Value) json.Value) func() Value {
+	return func() Value {
+		return &Computed{compute: compute, value: json.Null}
+	}
+}
+
+func (obj *Computed) Value() json.Value {
+	return obj.value
+}
+
+// update reruns compute, returning true if the value changed
+func (obj *Computed) update() bool {
+	obj.stale = false
+	val := obj.compute(obj.parent)
+	if val == nil {
+		val = json.Null
+	}
+	if val.JSON(false) == obj.value.JSON(false) {
+		return false
+	}
+	changingValue(obj)
+	obj.value = val
+	changedValue(obj)
+	return true
+}
+
+func (obj *Computed) WriteGroups() []string {
+	return obj.writeGroups
+}
+func (obj *Computed) AddWriteGroup(group ...string) {
+	obj.writeGroups = mergeGroups(obj.writeGroups, group)
+}
+func (obj *Computed) ReadGroups() []string {
+	return obj.readGroups
+}
+func (obj *Computed) AddReadGroup(group ...string) {
+	obj.readGroups = mergeGroups(obj.readGroups, group)
+}
+func (obj *Computed) SaveNeeded() bool       { return false }
+func (obj *Computed) SetSaveNeeded(val bool) {}
+func (obj *Computed) SkipSave() bool         { return true }
+func (obj *Computed) SetSkipSave(skip bool)  {}
+func (obj *Computed) Revision() uint64       { return obj.revision }
+func (obj *Computed) SetRevision(rev uint64) { obj.revision = rev }
+func (obj *Computed) Path() string           { return obj.path }
+func (obj *Computed) Parent() Value          { return obj.parent }
+func (obj *Computed) String() string         { return obj.value.JSON(false) }
+
+func (obj *Computed) SetParentAndPath(parent Value, path string) {
+	obj.parent = parent
+	obj.path = path
+	obj.stale = true
+	if s := storeOf(obj); s != nil {
+		s.addComputed(obj)
+	}
+	changedValue(obj)
+}
+
+func (obj *Computed) snapshot() func() {
+	value, revision := obj.value, obj.revision
+	return func() { obj.value, obj.revision = value, revision }
+}
+
+func (obj *Computed) JSON(skipSave bool) json.Value {
+	return obj.value
+}
+
+func (obj *Computed) SetJSON(j json.Value) error {
+	return errReadOnly
+}
+
+// addComputed registers obj with the store it was added to.  Values removed
+// from the store are dropped the next time the store looks.
+func (r *Store) addComputed(obj *Computed) {
+	if r.computed == nil {
+		r.computed = make(map[*Computed]bool)
+	}
+	r.computed[obj] = true
+}
+
+// recompute is called by Unlock to update the computed values.  Only the
+// ones whose parent holds a changed value are rerun.
+func (r *Store) recompute() {
+	if !r.changed {
+		return
+	}
+	start := 0
+	for pass := 0; pass < maxComputePasses; pass++ {
+		changed := r.changedValues[start:]
+		start = len(r.changedValues)
+		if len(changed) == 0 {
+			return
+		}
+		inputs, computed := changedInputs(changed)
+		for obj := range r.computed {
+			if storeOf(obj) != r {
+				delete(r.computed, obj)
+				continue
+			}
+			if obj.stale || inputs[obj.parent] || computedSibling(computed[obj.parent], obj) {
+				obj.update()
+			}
+		}
+	}
+	log.Critf("Computed values still changing after %v passes", maxComputePasses)
+}
+
+// changedInputs returns the values in changed and everything holding them.
+// A changed Computed is not an input to itself, so its parent is returned
+// in computed instead, with the Computed values changed in it.
+func changedInputs(changed []Value) (map[Value]bool, map[Value][]*Computed) {
+	inputs := make(map[Value]bool)
+	computed := make(map[Value][]*Computed)
+	for _, value := range changed {
+		if obj, ok := value.(*Computed); ok {
+			computed[obj.parent] = append(computed[obj.parent], obj)
+			value = obj.parent.Parent()
+		}
+		for ; value != nil && !inputs[value]; value = value.Parent() {
+			inputs[value] = true
+		}
+	}
+	return inputs, computed
+}
+
+func computedSibling(changed []*Computed, obj *Computed) bool {
+	for _, c := range changed {
+		if c != obj {
+			return true
+		}
+	}
+	return false
+}
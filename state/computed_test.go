@@ -0,0 +1,45 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/rollerderby/go/json"
+)
+
+func TestRecomputeChangedOnly(t *testing.T) {
+	runs := 0
+	newCounted := func() Value {
+		return &Object{Definition: ObjectDef{Name: "Counted", Values: []ObjectValueDef{
+			{Name: "ID", Initializer: NewGUID},
+			{Name: "Name", Initializer: NewString},
+			{Name: "Upper", Initializer: NewComputed(func(parent Value) json.Value {
+				runs++
+				return parent.(*Object).Get("Name").JSON(false)
+			})},
+		}}}
+	}
+	s := NewStore(t.TempDir())
+	h := NewHashOf(newCounted)().(*Hash)
+	s.Lock()
+	s.Add("Things", "", h)
+	first, _ := h.NewEmptyElement("")
+	second, _ := h.NewEmptyElement("")
+	s.Unlock()
+
+	runs = 0
+	s.Lock()
+	first.(*Object).Get("Name").(*String).SetValue("a")
+	s.Unlock()
+	if runs != 1 {
+		t.Fatalf("Expected 1 compute, got %v", runs)
+	}
+
+	s.RLock()
+	defer s.RUnlock()
+	if got := first.(*Object).Get("Upper").(*Computed).Value().JSON(false); got != `"a"` {
+		t.Fatalf("Computed is %v", got)
+	}
+	if got := second.(*Object).Get("Upper").(*Computed).Value().JSON(false); got != `""` {
+		t.Fatalf("Computed is %v", got)
+	}
+}
@@ -2,12 +2,15 @@ package state
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/rollerderby/go/json"
 )
 
+// Date is a calendar date with no time of day, sent as "2006-01-02".  The
+// zero time means no date and is sent as "".
 type Date struct {
-	value       string
+	value       time.Time
 	parent      Value
 	path        string
 	revision    uint64
@@ -17,27 +20,62 @@ type Date struct {
 	readGroups  []string
 }
 
+const dateLayout = "2006-01-02"
+
 func NewDate() Value { return &Date{} }
 
-func (obj *Date) Value() string {
+// Value returns the date at midnight UTC
+func (obj *Date) Value() time.Time {
 	return obj.value
 }
 
-func (obj *Date) SetValue(val string) error {
-	if obj.value == val {
+// SetValue keeps only the year, month and day of val, in val's location
+func (obj *Date) SetValue(val time.Time) error {
+	if !val.IsZero() {
+		y, m, d := val.Date()
+		val = time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	}
+	if obj.value.Equal(val) {
 		return nil
 	}
+	changingValue(obj)
 	obj.value = val
-	Root.changedValue(obj)
+	changedValue(obj)
 	return nil
 }
 
+// SetString parses a date as "2006-01-02" or RFC 3339.  "" clears the date.
+func (obj *Date) SetString(val string) error {
+	if val == "" {
+		return obj.SetValue(time.Time{})
+	}
+	t, err := time.Parse(dateLayout, val)
+	if err != nil {
+		var err2 error
+		if t, err2 = time.Parse(time.RFC3339, val); err2 != nil {
+			return errInvalidDate(val, err)
+		}
+	}
+	return obj.SetValue(t)
+}
+
+func (obj *Date) IsZero() bool            { return obj.value.IsZero() }
+func (obj *Date) Before(other *Date) bool { return obj.value.Before(other.value) }
+func (obj *Date) After(other *Date) bool  { return obj.value.After(other.value) }
+func (obj *Date) Equal(other *Date) bool  { return obj.value.Equal(other.value) }
+
+func (obj *Date) format() string {
+	if obj.value.IsZero() {
+		return ""
+	}
+	return obj.value.Format(dateLayout)
+}
+
 func (obj *Date) WriteGroups() []string {
 	return obj.writeGroups
 }
 func (obj *Date) AddWriteGroup(group ...string) {
 	obj.writeGroups = mergeGroups(obj.writeGroups, group)
-	obj.readGroups = mergeGroups(obj.readGroups, group)
 }
 func (obj *Date) ReadGroups() []string {
 	return obj.readGroups
@@ -53,23 +91,31 @@ func (obj *Date) Revision() uint64       { return obj.revision }
 func (obj *Date) SetRevision(rev uint64) { obj.revision = rev }
 func (obj *Date) Path() string           { return obj.path }
 func (obj *Date) Parent() Value          { return obj.parent }
-func (obj *Date) String() string         { return fmt.Sprintf("%q", obj.value) }
+func (obj *Date) String() string         { return fmt.Sprintf("%q", obj.format()) }
 
 func (obj *Date) SetParentAndPath(parent Value, path string) {
 	obj.parent = parent
 	obj.path = path
-	Root.changedValue(obj)
+	changedValue(obj)
+}
+
+func (obj *Date) snapshot() func() {
+	value, revision := obj.value, obj.revision
+	return func() { obj.value, obj.revision = value, revision }
 }
 
 func (obj *Date) JSON(skipSave bool) json.Value {
-	return json.NewString(obj.value)
+	return json.NewString(obj.format())
 }
 
 func (obj *Date) SetJSON(j json.Value) error {
+	if j == json.Null {
+		return obj.SetValue(time.Time{})
+	}
 	switch j := j.(type) {
 	case *json.String:
-		return obj.SetValue(j.Get())
+		return obj.SetString(j.Get())
 	default:
-		return errInvalidJSONType(j, json.StringValue)
+		return errInvalidJSONType(j, json.StringValue, json.NullValue)
 	}
 }
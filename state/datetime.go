@@ -0,0 +1,151 @@
+package state
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rollerderby/go/json"
+)
+
+// DateTime is an instant with a time zone, sent as RFC 3339 in its location.
+// Times given without an offset are taken to be in that location.  The zero
+// time means no value and is sent as "".
+type DateTime struct {
+	location    *time.Location
+	value       time.Time
+	parent      Value
+	path        string
+	revision    uint64
+	skipSave    bool
+	saveNeeded  bool
+	writeGroups []string
+	readGroups  []string
+}
+
+var localLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+}
+
+func NewDateTime() Value { return &DateTime{location: time.Local} }
+
+func NewDateTimeIn(loc *time.Location) func() Value {
+	return func() Value {
+		return &DateTime{location: loc}
+	}
+}
+
+// Value returns the time in the DateTime's location
+func (obj *DateTime) Value() time.Time {
+	return obj.value
+}
+
+func (obj *DateTime) Location() *time.Location {
+	return obj.location
+}
+
+func (obj *DateTime) SetValue(val time.Time) error {
+	if !val.IsZero() {
+		val = val.Round(0).In(obj.location)
+	}
+	if obj.value.Equal(val) {
+		return nil
+	}
+	changingValue(obj)
+	obj.value = val
+	changedValue(obj)
+	return nil
+}
+
+// SetString parses RFC 3339, or a time without an offset in the DateTime's
+// location.  "" clears the time.
+func (obj *DateTime) SetString(val string) error {
+	if val == "" {
+		return obj.SetValue(time.Time{})
+	}
+	t, err := time.Parse(time.RFC3339Nano, val)
+	if err != nil {
+		for _, layout := range localLayouts {
+			if t2, err2 := time.ParseInLocation(layout, val, obj.location); err2 == nil {
+				t, err = t2, nil
+				break
+			}
+		}
+	}
+	if err != nil {
+		return errInvalidDate(val, err)
+	}
+	return obj.SetValue(t)
+}
+
+func (obj *DateTime) IsZero() bool                { return obj.value.IsZero() }
+func (obj *DateTime) Before(other *DateTime) bool { return obj.value.Before(other.value) }
+func (obj *DateTime) After(other *DateTime) bool  { return obj.value.After(other.value) }
+func (obj *DateTime) Equal(other *DateTime) bool  { return obj.value.Equal(other.value) }
+
+// Date returns the calendar date of the time in its location
+func (obj *DateTime) Date() time.Time {
+	if obj.value.IsZero() {
+		return time.Time{}
+	}
+	y, m, d := obj.value.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+func (obj *DateTime) format() string {
+	if obj.value.IsZero() {
+		return ""
+	}
+	return obj.value.Format(time.RFC3339Nano)
+}
+
+func (obj *DateTime) WriteGroups() []string {
+	return obj.writeGroups
+}
+func (obj *DateTime) AddWriteGroup(group ...string) {
+	obj.writeGroups = mergeGroups(obj.writeGroups, group)
+}
+func (obj *DateTime) ReadGroups() []string {
+	return obj.readGroups
+}
+func (obj *DateTime) AddReadGroup(group ...string) {
+	obj.readGroups = mergeGroups(obj.readGroups, group)
+}
+func (obj *DateTime) SaveNeeded() bool       { return obj.saveNeeded }
+func (obj *DateTime) SetSaveNeeded(val bool) { obj.saveNeeded = val }
+func (obj *DateTime) SkipSave() bool         { return obj.skipSave }
+func (obj *DateTime) SetSkipSave(skip bool)  { obj.skipSave = skip }
+func (obj *DateTime) Revision() uint64       { return obj.revision }
+func (obj *DateTime) SetRevision(rev uint64) { obj.revision = rev }
+func (obj *DateTime) Path() string           { return obj.path }
+func (obj *DateTime) Parent() Value          { return obj.parent }
+func (obj *DateTime) String() string         { return fmt.Sprintf("%q", obj.format()) }
+
+func (obj *DateTime) SetParentAndPath(parent Value, path string) {
+	obj.parent = parent
+	obj.path = path
+	changedValue(obj)
+}
+
+func (obj *DateTime) snapshot() func() {
+	value, revision := obj.value, obj.revision
+	return func() { obj.value, obj.revision = value, revision }
+}
+
+func (obj *DateTime) JSON(skipSave bool) json.Value {
+	return json.NewString(obj.format())
+}
+
+func (obj *DateTime) SetJSON(j json.Value) error {
+	if j == json.Null {
+		return obj.SetValue(time.Time{})
+	}
+	switch j := j.(type) {
+	case *json.String:
+		return obj.SetString(j.Get())
+	default:
+		return errInvalidJSONType(j, json.StringValue, json.NullValue)
+	}
+}
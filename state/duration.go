@@ -0,0 +1,186 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rollerderby/go/json"
+)
+
+// Duration is a length of time with millisecond precision, sent as "m:ss" or
+// "h:mm:ss" with ".mmm" added when there are milliseconds.  It also accepts a
+// plain number of milliseconds.
+type Duration struct {
+	value       time.Duration
+	parent      Value
+	path        string
+	revision    uint64
+	skipSave    bool
+	saveNeeded  bool
+	writeGroups []string
+	readGroups  []string
+}
+
+func NewDuration() Value { return &Duration{} }
+
+func (obj *Duration) Value() time.Duration {
+	return obj.value
+}
+
+// SetValue drops anything below a millisecond
+func (obj *Duration) SetValue(val time.Duration) error {
+	val = val / time.Millisecond * time.Millisecond
+	if obj.value == val {
+		return nil
+	}
+	changingValue(obj)
+	obj.value = val
+	changedValue(obj)
+	return nil
+}
+
+func (obj *Duration) SetString(val string) error {
+	d, err := ParseDuration(val)
+	if err != nil {
+		return err
+	}
+	return obj.SetValue(d)
+}
+
+// ParseDuration parses "m:ss", "h:mm:ss" (either with an optional ".mmm") or
+// a number of milliseconds.  The first field may be any size, so "60:00" is an
+// hour.
+func ParseDuration(val string) (time.Duration, error) {
+	s := strings.TrimSpace(val)
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+
+	fields := strings.Split(s, ":")
+	if len(fields) > 3 || s == "" {
+		return 0, errInvalidDuration(val, nil)
+	}
+	if len(fields) == 1 {
+		ms, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return 0, errInvalidDuration(val, err)
+		}
+		d := time.Duration(ms) * time.Millisecond
+		if neg {
+			d = -d
+		}
+		return d, nil
+	}
+
+	// The last field is seconds, with optional milliseconds
+	last := fields[len(fields)-1]
+	var ms int64
+	if dot := strings.IndexByte(last, '.'); dot >= 0 {
+		frac := last[dot+1:]
+		if len(frac) == 0 || len(frac) > 3 {
+			return 0, errInvalidDuration(val, errors.New("expected 1 to 3 digits of milliseconds"))
+		}
+		var err error
+		if ms, err = strconv.ParseInt(frac+strings.Repeat("0", 3-len(frac)), 10, 64); err != nil {
+			return 0, errInvalidDuration(val, err)
+		}
+		last = last[:dot]
+	}
+	fields[len(fields)-1] = last
+
+	var total int64
+	for idx, field := range fields {
+		n, err := strconv.ParseInt(field, 10, 64)
+		if err != nil || n < 0 || len(field) == 0 {
+			return 0, errInvalidDuration(val, err)
+		}
+		if idx > 0 && (n >= 60 || len(field) != 2) {
+			return 0, errInvalidDuration(val, errors.New("minutes and seconds must be 00 to 59"))
+		}
+		total = total*60 + n
+	}
+
+	d := time.Duration(total)*time.Second + time.Duration(ms)*time.Millisecond
+	if neg {
+		d = -d
+	}
+	return d, nil
+}
+
+// FormatDuration is the inverse of ParseDuration, using "h:mm:ss" only for an
+// hour or more
+func FormatDuration(d time.Duration) string {
+	sign := ""
+	if d < 0 {
+		sign = "-"
+		d = -d
+	}
+	ms := int64(d / time.Millisecond)
+	secs := ms / 1000
+	ms = ms % 1000
+
+	var ret string
+	if secs >= 3600 {
+		ret = fmt.Sprintf("%v%d:%02d:%02d", sign, secs/3600, secs/60%60, secs%60)
+	} else {
+		ret = fmt.Sprintf("%v%d:%02d", sign, secs/60, secs%60)
+	}
+	if ms != 0 {
+		ret += fmt.Sprintf(".%03d", ms)
+	}
+	return ret
+}
+
+func (obj *Duration) WriteGroups() []string {
+	return obj.writeGroups
+}
+func (obj *Duration) AddWriteGroup(group ...string) {
+	obj.writeGroups = mergeGroups(obj.writeGroups, group)
+}
+func (obj *Duration) ReadGroups() []string {
+	return obj.readGroups
+}
+func (obj *Duration) AddReadGroup(group ...string) {
+	obj.readGroups = mergeGroups(obj.readGroups, group)
+}
+func (obj *Duration) SaveNeeded() bool       { return obj.saveNeeded }
+func (obj *Duration) SetSaveNeeded(val bool) { obj.saveNeeded = val }
+func (obj *Duration) SkipSave() bool         { return obj.skipSave }
+func (obj *Duration) SetSkipSave(skip bool)  { obj.skipSave = skip }
+func (obj *Duration) Revision() uint64       { return obj.revision }
+func (obj *Duration) SetRevision(rev uint64) { obj.revision = rev }
+func (obj *Duration) Path() string           { return obj.path }
+func (obj *Duration) Parent() Value          { return obj.parent }
+func (obj *Duration) String() string         { return fmt.Sprintf("%q", FormatDuration(obj.value)) }
+
+func (obj *Duration) SetParentAndPath(parent Value, path string) {
+	obj.parent = parent
+	obj.path = path
+	changedValue(obj)
+}
+
+func (obj *Duration) snapshot() func() {
+	value, revision := obj.value, obj.revision
+	return func() { obj.value, obj.revision = value, revision }
+}
+
+func (obj *Duration) JSON(skipSave bool) json.Value {
+	return json.NewString(FormatDuration(obj.value))
+}
+
+func (obj *Duration) SetJSON(j json.Value) error {
+	switch j := j.(type) {
+	case *json.String:
+		return obj.SetString(j.Get())
+	case *json.Number:
+		if ms, err := j.GetInt64(); err != nil {
+			return errInvalidJSONValue(j, err)
+		} else {
+			return obj.SetValue(time.Duration(ms) * time.Millisecond)
+		}
+	default:
+		return errInvalidJSONType(j, json.StringValue, json.NumberValue)
+	}
+}
@@ -0,0 +1,68 @@
+package state
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		val      string
+		expected time.Duration
+		format   string
+	}{
+		{"0:00", 0, "0:00"},
+		{"1:05", time.Minute + 5*time.Second, "1:05"},
+		{"60:00", time.Hour, "1:00:00"},
+		{"1:02:03", time.Hour + 2*time.Minute + 3*time.Second, "1:02:03"},
+		{"0:01.5", 1500 * time.Millisecond, "0:01.500"},
+		{"2:00.025", 2*time.Minute + 25*time.Millisecond, "2:00.025"},
+		{"-0:30", -30 * time.Second, "-0:30"},
+		{"-1:00:00.001", -(time.Hour + time.Millisecond), "-1:00:00.001"},
+		{"1500", 1500 * time.Millisecond, "0:01.500"},
+		{"-90000", -90 * time.Second, "-1:30"},
+		{" 1:00 ", time.Minute, "1:00"},
+	}
+	for _, test := range tests {
+		d, err := ParseDuration(test.val)
+		if err != nil {
+			t.Fatalf("%q: %v", test.val, err)
+		}
+		if d != test.expected {
+			t.Fatalf("%q: expected %v, got %v", test.val, test.expected, d)
+		}
+		if format := FormatDuration(d); format != test.format {
+			t.Fatalf("%q: expected %q, got %q", test.val, test.format, format)
+		}
+		if d2, err := ParseDuration(FormatDuration(d)); err != nil || d2 != d {
+			t.Fatalf("%q: round trip gave %v, %v", test.val, d2, err)
+		}
+	}
+}
+
+func TestParseDurationInvalid(t *testing.T) {
+	for _, val := range []string{"", "-", ":", "1:75", "1:5", "1:60:00", "1:2:03", "1:00:00:00", "a:00", "1:-5", "1:00.", "1:00.1234", "1:00.ab", "1.5"} {
+		if d, err := ParseDuration(val); err == nil {
+			t.Fatalf("%q: expected an error, got %v", val, d)
+		}
+	}
+}
+
+func TestDurationValue(t *testing.T) {
+	d := NewDuration().(*Duration)
+	if err := d.SetValue(1234567 * time.Microsecond); err != nil {
+		t.Fatal(err)
+	}
+	if d.Value() != 1234*time.Millisecond {
+		t.Fatalf("Expected milliseconds only, got %v", d.Value())
+	}
+	if err := d.SetString("1:75"); err == nil {
+		t.Fatal("Expected an error")
+	}
+	if d.Value() != 1234*time.Millisecond {
+		t.Fatalf("Value changed to %v", d.Value())
+	}
+	if s := d.String(); s != `"0:01.234"` {
+		t.Fatalf("String is %v", s)
+	}
+}
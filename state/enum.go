@@ -32,8 +32,9 @@ func (obj *Enum) Value() string {
 func (obj *Enum) SetValue(val string) error {
 	if val == "" {
 		if val != obj.value {
+			changingValue(obj)
 			obj.value = val
-			Root.changedValue(obj)
+			changedValue(obj)
 		}
 		return nil
 	} else {
@@ -43,8 +44,12 @@ func (obj *Enum) SetValue(val string) error {
 				if obj.value == val2 {
 					return nil
 				}
+				if err := checkUnique(obj, val2); err != nil {
+					return err
+				}
+				changingValue(obj)
 				obj.value = val2
-				Root.changedValue(obj)
+				changedValue(obj)
 				return nil
 			}
 		}
@@ -57,7 +62,6 @@ func (obj *Enum) WriteGroups() []string {
 }
 func (obj *Enum) AddWriteGroup(group ...string) {
 	obj.writeGroups = mergeGroups(obj.writeGroups, group)
-	obj.readGroups = mergeGroups(obj.readGroups, group)
 }
 func (obj *Enum) ReadGroups() []string {
 	return obj.readGroups
@@ -78,7 +82,12 @@ func (obj *Enum) String() string         { return fmt.Sprintf("%q", obj.value) }
 func (obj *Enum) SetParentAndPath(parent Value, path string) {
 	obj.parent = parent
 	obj.path = path
-	Root.changedValue(obj)
+	changedValue(obj)
+}
+
+func (obj *Enum) snapshot() func() {
+	value, revision := obj.value, obj.revision
+	return func() { obj.value, obj.revision = value, revision }
 }
 
 func (obj *Enum) JSON(skipSave bool) json.Value {
@@ -18,6 +18,20 @@ type (
 	ErrExistingKey      error
 	ErrInvalidEnum      error
 	ErrNoKey            error
+	ErrUnknownRoot      error
+	ErrInvalidPath      error
+	ErrUnknownPath      error
+	ErrInvalidIndex     error
+	ErrConstraint       error
+	ErrInvalidDate      error
+	ErrInvalidDuration  error
+	ErrReadOnly         error
+	ErrUnknownKey       error
+	ErrInvalidReference error
+	ErrRestricted       error
+	ErrDuplicate        error
+	ErrSchemaVersion    error
+	ErrMigration        error
 )
 
 var (
@@ -25,6 +39,8 @@ var (
 	errNoInitializer  = ErrNoInitializer(errors.New("No Initalizer"))
 	errNotImplemented = ErrNotImplemented(errors.New("Not Implemented"))
 	errNoKey          = ErrNotImplemented(errors.New("Key is missing"))
+	errReadOnly       = ErrReadOnly(errors.New("Value is read only"))
+	errReadOnlyStore  = ErrReadOnly(errors.New("Storage is read only"))
 )
 
 func errInvalidJSONType(j json.Value, expectedTypes ...json.ValueType) ErrInvalidJSONType {
@@ -75,6 +91,73 @@ func errExistingKey(key string) ErrExistingKey {
 	return ErrExistingKey(fmt.Errorf("Existing key in root: %q", key))
 }
 
+func errUnknownRoot(key string) ErrUnknownRoot {
+	return ErrUnknownRoot(fmt.Errorf("Unknown key in root: %q", key))
+}
+
+func errInvalidPath(path string) ErrInvalidPath {
+	return ErrInvalidPath(fmt.Errorf("Invalid path: %q", path))
+}
+
+func errUnknownPath(path string) ErrUnknownPath {
+	return ErrUnknownPath(fmt.Errorf("Unknown path: %q", path))
+}
+
+func errInvalidIndex(idx int) ErrInvalidIndex {
+	return ErrInvalidIndex(fmt.Errorf("Invalid index: %v", idx))
+}
+
+func errConstraint(value Value, val interface{}, reason string) ErrConstraint {
+	return ErrConstraint(fmt.Errorf("Invalid value %#v for %q: %v", val, value.Path(), reason))
+}
+
+func errInvalidDate(val string, err error) ErrInvalidDate {
+	return ErrInvalidDate(fmt.Errorf("Invalid date %q: %v", val, err))
+}
+
+func errInvalidDuration(val string, err error) ErrInvalidDuration {
+	if err == nil {
+		return ErrInvalidDuration(fmt.Errorf("Invalid duration %q", val))
+	}
+	return ErrInvalidDuration(fmt.Errorf("Invalid duration %q: %v", val, err))
+}
+
+func errUnknownKey(key string) ErrUnknownKey {
+	return ErrUnknownKey(fmt.Errorf("Unknown key: %q", key))
+}
+
+func errInvalidReference(root, key string) ErrInvalidReference {
+	return ErrInvalidReference(fmt.Errorf("No %v with key %q", root, key))
+}
+
+func errRestricted(root, key, path string) ErrRestricted {
+	return ErrRestricted(fmt.Errorf("Cannot delete %v[%v], it is referenced by %v", root, key, path))
+}
+
+func errDuplicate(path, field, val string) ErrDuplicate {
+	return ErrDuplicate(fmt.Errorf("%v already has an element with %v %q", path, field, val))
+}
+
+// ErrConflict is returned by writes that expected an older revision of a value
+type ErrConflict struct {
+	Path     string
+	Revision uint64     // The latest revision of the value
+	Current  json.Value // The value as it is now
+	Value    Value
+}
+
+func (e *ErrConflict) Error() string {
+	return fmt.Sprintf("%v changed at revision %v", e.Path, e.Revision)
+}
+
+func errSchemaVersion(name string, version, current int) ErrSchemaVersion {
+	return ErrSchemaVersion(fmt.Errorf("Unexpected schema version %v for %v, current version is %v", version, name, current))
+}
+
+func errMigration(name, key string, version int, err error) ErrMigration {
+	return ErrMigration(fmt.Errorf("Cannot migrate %v[%v] from schema version %v: %v", name, key, version, err))
+}
+
 func errInvalidEnum(val string, values []string) ErrInvalidEnum {
 	return ErrInvalidEnum(fmt.Errorf("Invalid enum %q, not in %q", val, values))
 }
@@ -0,0 +1,254 @@
+package state
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/rollerderby/go/json"
+)
+
+func loadJSON(filename string) (json.Value, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return json.Decode(data)
+}
+
+// loadJSONDir loads every .json file in dir, keyed by the file name without
+// the extension
+func loadJSONDir(dir string) (map[string]json.Value, []error) {
+	var errors []error
+
+	files, err := jsonFiles(dir)
+	if err != nil {
+		return nil, append(errors, err)
+	}
+
+	ret := make(map[string]json.Value)
+	for _, fi := range files {
+		key := fi.Name()
+		key = key[:len(key)-len(path.Ext(fi.Name()))]
+
+		json, err := loadJSON(path.Join(dir, fi.Name()))
+		if err != nil {
+			errors = append(errors, err)
+			continue
+		}
+		ret[key] = json
+	}
+	return ret, errors
+}
+
+// jsonFiles lists the .json files in dir
+func jsonFiles(dir string) ([]os.FileInfo, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []os.FileInfo
+	for _, fi := range files {
+		// Skip anything that isn't a config, like temp files from writeFileAtomic
+		if fi.IsDir() || strings.HasPrefix(fi.Name(), ".") || path.Ext(fi.Name()) != ".json" {
+			continue
+		}
+		ret = append(ret, fi)
+	}
+	return ret, nil
+}
+
+// dirStorage keeps a root in <dir>/<name>.json, or a hash in one file per
+// key under <dir>/<name>/
+type dirStorage struct {
+	dir      string
+	readOnly bool
+	stamps   map[string]fileStamp // Files as last loaded or saved, to spot edits
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// NewDirStorage stores configs as JSON files in dir.  Files edited by hand
+// are picked up by Changes.
+func NewDirStorage(dir string, readOnly bool) (Storage, error) {
+	return &dirStorage{dir: dir, readOnly: readOnly, stamps: make(map[string]fileStamp)}, nil
+}
+
+func (s *dirStorage) Load(name string) (json.Value, error) {
+	filename := path.Join(s.dir, name+".json")
+	s.stamp(filename)
+	return loadJSON(filename)
+}
+
+func (s *dirStorage) LoadHash(name string) (map[string]json.Value, []error) {
+	dir := path.Join(s.dir, name)
+	if files, err := jsonFiles(dir); err == nil {
+		for _, fi := range files {
+			s.stamps[path.Join(dir, fi.Name())] = fileStamp{fi.ModTime(), fi.Size()}
+		}
+	}
+	return loadJSONDir(dir)
+}
+
+// Changes returns the files added, changed or removed since they were last
+// loaded or saved.  Files that cannot be read are returned as errors, and
+// not again until they change.
+func (s *dirStorage) Changes(values, hashes []string) ([]StorageChange, []error) {
+	var changes []StorageChange
+	var errors []error
+	changed := func(filename string, fi os.FileInfo) bool {
+		stamp, ok := s.stamps[filename]
+		s.stamps[filename] = fileStamp{fi.ModTime(), fi.Size()}
+		return !ok || !stamp.modTime.Equal(fi.ModTime()) || stamp.size != fi.Size()
+	}
+
+	for _, name := range values {
+		filename := path.Join(s.dir, name+".json")
+		fi, err := os.Stat(filename)
+		if err != nil {
+			if _, ok := s.stamps[filename]; ok && os.IsNotExist(err) {
+				changes = append(changes, StorageChange{Name: name})
+			}
+			delete(s.stamps, filename)
+			continue
+		}
+		if !changed(filename, fi) {
+			continue
+		}
+		if j, err := loadJSON(filename); err != nil {
+			errors = append(errors, fmt.Errorf("%v: %v", filename, err))
+		} else {
+			changes = append(changes, StorageChange{Name: name, Value: j})
+		}
+	}
+
+	for _, name := range hashes {
+		dir := path.Join(s.dir, name)
+		files, _ := jsonFiles(dir)
+		seen := make(map[string]bool)
+		for _, fi := range files {
+			filename := path.Join(dir, fi.Name())
+			seen[filename] = true
+			if !changed(filename, fi) {
+				continue
+			}
+			key := fi.Name()[:len(fi.Name())-len(".json")]
+			if j, err := loadJSON(filename); err != nil {
+				errors = append(errors, fmt.Errorf("%v: %v", filename, err))
+			} else {
+				changes = append(changes, StorageChange{Name: name, Key: key, Value: j})
+			}
+		}
+		for filename := range s.stamps {
+			if path.Dir(filename) == dir && !seen[filename] {
+				delete(s.stamps, filename)
+				key := path.Base(filename)
+				changes = append(changes, StorageChange{Name: name, Key: key[:len(key)-len(".json")]})
+			}
+		}
+	}
+	return changes, errors
+}
+
+func (s *dirStorage) Retry(change StorageChange) {
+	filename := s.filename(change)
+	if change.Value == nil {
+		// Still reported as deleted while the stamp is there
+		s.stamps[filename] = fileStamp{}
+	} else {
+		delete(s.stamps, filename)
+	}
+}
+
+func (s *dirStorage) filename(change StorageChange) string {
+	if change.Key != "" {
+		return path.Join(s.dir, change.Name, change.Key+".json")
+	}
+	return path.Join(s.dir, change.Name+".json")
+}
+
+func (s *dirStorage) stamp(filename string) {
+	if fi, err := os.Stat(filename); err == nil {
+		s.stamps[filename] = fileStamp{fi.ModTime(), fi.Size()}
+	} else {
+		delete(s.stamps, filename)
+	}
+}
+
+func (s *dirStorage) Save(changes []StorageChange) error {
+	if s.readOnly {
+		return errReadOnlyStore
+	}
+	var firstErr error
+	for _, change := range changes {
+		filename := s.filename(change)
+
+		var err error
+		if change.Value == nil {
+			err = removeJSON(filename)
+		} else {
+			err = saveJSON(filename, change.Value)
+		}
+		s.stamp(filename)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (s *dirStorage) Close() error { return nil }
+
+func saveJSON(filename string, json json.Value) error {
+	dir := path.Dir(filename)
+	if err := os.MkdirAll(dir, 0775); err != nil {
+		return fmt.Errorf("Cannot create directory %q: %v", dir, err)
+	} else if err := writeFileAtomic(filename, []byte(json.JSON(true)), 0664); err != nil {
+		return fmt.Errorf("Cannot save config file %q: %v", filename, err)
+	}
+	log.Infof("Saved config file %q", filename)
+	return nil
+}
+
+func removeJSON(filename string) error {
+	if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Cannot remove config file %q: %v", filename, err)
+	}
+	log.Infof("Removed config file %q", filename)
+	return nil
+}
+
+// writeFileAtomic writes to a temp file next to filename and renames it over
+// filename, so an interrupted write leaves the old file in place
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(path.Dir(filename), "."+path.Base(filename)+".")
+	if err != nil {
+		return err
+	}
+	tmpName := f.Name()
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpName, perm)
+	}
+	if err == nil {
+		err = os.Rename(tmpName, filename)
+	}
+	if err != nil {
+		os.Remove(tmpName)
+	}
+	return err
+}
@@ -0,0 +1,135 @@
+package state
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/rollerderby/go/json"
+)
+
+type Float struct {
+	def         *FloatDef
+	value       float64
+	parent      Value
+	path        string
+	revision    uint64
+	skipSave    bool
+	saveNeeded  bool
+	writeGroups []string
+	readGroups  []string
+}
+
+// FloatDef limits the values a Float accepts and sets its initial value
+type FloatDef struct {
+	Min     *float64
+	Max     *float64
+	Default float64
+}
+
+func NewFloat() Value { return &Float{} }
+
+func NewFloatOf(def FloatDef) func() Value {
+	return func() Value {
+		return &Float{def: &def, value: def.Default}
+	}
+}
+
+// Float64 returns a pointer to val, for FloatDef.Min and Max
+func Float64(val float64) *float64 { return &val }
+
+func (obj *Float) Value() float64 {
+	return obj.value
+}
+
+func (obj *Float) SetValue(val float64) error {
+	if err := obj.check(val); err != nil {
+		return err
+	}
+	if obj.value == val {
+		return nil
+	}
+	changingValue(obj)
+	obj.value = val
+	changedValue(obj)
+	return nil
+}
+
+// check returns an ErrConstraint if val is not finite or breaks the FloatDef
+func (obj *Float) check(val float64) error {
+	if math.IsNaN(val) || math.IsInf(val, 0) {
+		return errConstraint(obj, val, "not a finite number")
+	}
+	if obj.def == nil {
+		return nil
+	}
+	if obj.def.Min != nil && val < *obj.def.Min {
+		return errConstraint(obj, val, "less than "+formatFloat(*obj.def.Min))
+	}
+	if obj.def.Max != nil && val > *obj.def.Max {
+		return errConstraint(obj, val, "more than "+formatFloat(*obj.def.Max))
+	}
+	return nil
+}
+
+func (obj *Float) validate() error { return obj.check(obj.value) }
+
+// formatFloat uses the shortest form that parses back to the same value
+func formatFloat(val float64) string {
+	return strconv.FormatFloat(val, 'g', -1, 64)
+}
+
+func (obj *Float) WriteGroups() []string {
+	return obj.writeGroups
+}
+func (obj *Float) AddWriteGroup(group ...string) {
+	obj.writeGroups = mergeGroups(obj.writeGroups, group)
+}
+func (obj *Float) ReadGroups() []string {
+	return obj.readGroups
+}
+func (obj *Float) AddReadGroup(group ...string) {
+	obj.readGroups = mergeGroups(obj.readGroups, group)
+}
+func (obj *Float) SaveNeeded() bool       { return obj.saveNeeded }
+func (obj *Float) SetSaveNeeded(val bool) { obj.saveNeeded = val }
+func (obj *Float) SkipSave() bool         { return obj.skipSave }
+func (obj *Float) SetSkipSave(skip bool)  { obj.skipSave = skip }
+func (obj *Float) Revision() uint64       { return obj.revision }
+func (obj *Float) SetRevision(rev uint64) { obj.revision = rev }
+func (obj *Float) Path() string           { return obj.path }
+func (obj *Float) Parent() Value          { return obj.parent }
+func (obj *Float) String() string         { return formatFloat(obj.value) }
+
+func (obj *Float) SetParentAndPath(parent Value, path string) {
+	obj.parent = parent
+	obj.path = path
+	changedValue(obj)
+}
+
+func (obj *Float) snapshot() func() {
+	value, revision := obj.value, obj.revision
+	return func() { obj.value, obj.revision = value, revision }
+}
+
+func (obj *Float) JSON(skipSave bool) json.Value {
+	return json.NewFloat(obj.value)
+}
+
+func (obj *Float) SetJSON(j json.Value) error {
+	switch j := j.(type) {
+	case *json.String:
+		if val, err := j.AsNumber().GetFloat64(); err != nil {
+			return errInvalidJSONValue(j, err)
+		} else {
+			return obj.SetValue(val)
+		}
+	case *json.Number:
+		if val, err := j.GetFloat64(); err != nil {
+			return errInvalidJSONValue(j, err)
+		} else {
+			return obj.SetValue(val)
+		}
+	default:
+		return errInvalidJSONType(j, json.NumberValue, conversionTypes, json.StringValue)
+	}
+}
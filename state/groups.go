@@ -0,0 +1,172 @@
+package state
+
+import (
+	"strconv"
+
+	"github.com/rollerderby/go/json"
+)
+
+// Member is a user checked against ReadGroups and WriteGroups.  A nil Member
+// is an anonymous user.
+type Member interface {
+	HasGroup(groups ...string) bool
+}
+
+// CanRead reports if m may read value.  Groups are inherited, so every value
+// from the root down to value must allow m.  An empty list of groups allows
+// everyone, and anyone allowed to write a value may also read it.  Values
+// marked Secret in their ObjectValueDef can't be read by anyone.
+func CanRead(value Value, m Member) bool {
+	for ; value != nil; value = value.Parent() {
+		if !canReadOne(value, m) {
+			return false
+		}
+	}
+	return true
+}
+
+// CanWrite reports if m may change value, using the same rules as CanRead.
+// Writing a container writes everything in it, so every descendant must
+// allow m too.  Computed values can never be written.
+func CanWrite(value Value, m Member) bool {
+	if _, ok := value.(*Computed); ok {
+		return false
+	}
+	for v := value.Parent(); v != nil; v = v.Parent() {
+		if !allowed(v.WriteGroups(), m) {
+			return false
+		}
+	}
+	ok := true
+	Walk(value, func(v Value) bool {
+		ok = ok && allowed(v.WriteGroups(), m)
+		return ok
+	})
+	return ok
+}
+
+// ReadableJSON is like value.JSON(false) but leaves out any descendant m is
+// not allowed to read.  The caller is expected to have checked CanRead on
+// value itself.
+func ReadableJSON(value Value, m Member) json.Value {
+	switch value := value.(type) {
+	case *Object:
+		j := make(json.Object)
+		for _, def := range value.Definition.Values {
+			if child := value.Get(def.Name); canReadOne(child, m) {
+				j[def.Name] = ReadableJSON(child, m)
+			}
+		}
+		return j
+	case *Hash:
+		j := make(json.Object)
+		for _, key := range value.Keys() {
+			if child := value.Get(key); canReadOne(child, m) {
+				j[key] = ReadableJSON(child, m)
+			}
+		}
+		return j
+	case *Array:
+		var j json.Array
+		for _, child := range value.Values() {
+			if canReadOne(child, m) {
+				j = append(j, ReadableJSON(child, m))
+			}
+		}
+		return j
+	}
+	return value.JSON(false)
+}
+
+// ReadableSnapshot is like ReadableJSON for j, an earlier JSON(false) of
+// value.  Parts of j value no longer has are checked against a new element
+// of their hash or array, and left out if there is none.
+func ReadableSnapshot(value Value, j json.Value, m Member) json.Value {
+	return filterSnapshot(value, j, func(parent Value, key string, child Value) bool {
+		return canReadOne(child, m)
+	})
+}
+
+// filterSnapshot returns j, an earlier JSON(false) of value, with only the
+// parts keep allows.  keep is given each child, or a new element standing in
+// for one value no longer has, with its parent and key.
+func filterSnapshot(value Value, j json.Value, keep func(parent Value, key string, child Value) bool) json.Value {
+	switch j := j.(type) {
+	case json.Object:
+		ret := make(json.Object)
+		for key, child := range j {
+			if v := childOrNew(value, key); v != nil && keep(value, key, v) {
+				ret[key] = filterSnapshot(v, child, keep)
+			}
+		}
+		return ret
+	case json.Array:
+		var ret json.Array
+		for idx, child := range j {
+			key := strconv.Itoa(idx)
+			if v := childOrNew(value, key); v != nil && keep(value, key, v) {
+				ret = append(ret, filterSnapshot(v, child, keep))
+			}
+		}
+		return ret
+	}
+	return j
+}
+
+// childOrNew returns the child of value named key, or a new element of value
+// to check groups against if key isn't there
+func childOrNew(value Value, key string) Value {
+	if child := Child(value, key); child != nil {
+		return child
+	}
+	switch value := value.(type) {
+	case *Hash:
+		if value.initializer != nil {
+			return value.initializer()
+		}
+	case *Array:
+		if value.initializer != nil {
+			return value.initializer()
+		}
+	}
+	return nil
+}
+
+func canReadOne(value Value, m Member) bool {
+	if isSecretValue(value) {
+		return false
+	}
+	readGroups := value.ReadGroups()
+	if len(readGroups) == 0 {
+		return true
+	}
+	if allowed(readGroups, m) {
+		return true
+	}
+	writeGroups := value.WriteGroups()
+	return len(writeGroups) > 0 && allowed(writeGroups, m)
+}
+
+// isSecretValue reports if value is marked Secret in the object holding it
+func isSecretValue(value Value) bool {
+	obj, ok := value.Parent().(*Object)
+	if !ok {
+		return false
+	}
+	for _, def := range obj.Definition.Values {
+		if def.Secret && obj.Get(def.Name) == value {
+			return true
+		}
+	}
+	return false
+}
+
+func allowed(groups []string, m Member) bool {
+	if len(groups) == 0 {
+		return true
+	}
+	if m == nil {
+		return false
+	}
+	return m.HasGroup(groups...)
+}
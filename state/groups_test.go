@@ -0,0 +1,112 @@
+package state
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rollerderby/go/json"
+)
+
+type testMember []string
+
+func (m testMember) HasGroup(groups ...string) bool {
+	for _, g := range groups {
+		for _, h := range m {
+			if g == h {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func newSecretObject() Value {
+	return &Object{Definition: ObjectDef{Name: "Secret", Values: []ObjectValueDef{
+		{Name: "ID", Initializer: NewGUID},
+		{Name: "Name", Initializer: NewString},
+		{Name: "Secret", Initializer: NewString, ReadGroups: []string{"admin"}, WriteGroups: []string{"admin"}},
+	}}}
+}
+
+func TestCanWriteDescendants(t *testing.T) {
+	obj := newSecretObject().(*Object)
+	user, admin := testMember{"user"}, testMember{"admin"}
+
+	if !CanWrite(obj.Get("Name"), user) {
+		t.Fatal("user cannot write Name")
+	}
+	if CanWrite(obj.Get("Secret"), user) {
+		t.Fatal("user can write Secret")
+	}
+	if CanWrite(obj, user) {
+		t.Fatal("user can write the object holding Secret")
+	}
+	if !CanWrite(obj, admin) {
+		t.Fatal("admin cannot write the object")
+	}
+}
+
+func TestReadableSnapshot(t *testing.T) {
+	h := NewHashOf(newSecretObject)().(*Hash)
+	elem, err := h.NewElement("", json.Object{"ID": json.NewString(""), "Name": json.NewString("a"), "Secret": json.NewString("s")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := elem.(*Object).Get("ID").(*GUID).Value()
+	old := h.JSON(false)
+	if err := h.Delete(id); err != nil {
+		t.Fatal(err)
+	}
+
+	// The element is gone, so its groups come from a new element
+	j := ReadableSnapshot(h, old, testMember{"user"}).(json.Object)
+	e, ok := j[id].(json.Object)
+	if !ok {
+		t.Fatalf("element missing from %v", j.JSON(false))
+	}
+	if _, ok := e["Secret"]; ok {
+		t.Fatalf("Secret readable in %v", j.JSON(false))
+	}
+	if _, ok := e["Name"]; !ok {
+		t.Fatalf("Name missing from %v", j.JSON(false))
+	}
+}
+
+func TestSecretsNotReadable(t *testing.T) {
+	s := NewStore(t.TempDir())
+	h := NewHashOf(func() Value {
+		return &Object{Definition: ObjectDef{Name: "User", Values: []ObjectValueDef{
+			{Name: "ID", Initializer: NewGUID},
+			{Name: "Name", Initializer: NewString},
+			{Name: "PasswordHash", Initializer: NewString, ReadGroups: []string{"admin"}, WriteGroups: []string{"admin"}, Secret: true},
+		}}}
+	})().(*Hash)
+	s.Lock()
+	s.Add("Users", "", h)
+	s.isReady = true
+	s.Unlock()
+	admin := testMember{"admin"}
+
+	s.Lock()
+	elem, _ := h.NewEmptyElement("")
+	hash := elem.(*Object).Get("PasswordHash").(*String)
+	hash.SetValue("secret")
+	s.Unlock()
+
+	s.RLock()
+	defer s.RUnlock()
+	if CanRead(hash, admin) {
+		t.Fatal("admin can read a Secret")
+	}
+	if !CanWrite(hash, admin) {
+		t.Fatal("admin cannot write a Secret")
+	}
+	if j := ReadableJSON(h, admin).JSON(false); strings.Contains(j, "secret") || strings.Contains(j, "PasswordHash") {
+		t.Fatalf("Secret in ReadableJSON: %v", j)
+	}
+	for _, cs := range s.History() {
+		if j := cs.ReadableJSON(admin).JSON(false); strings.Contains(j, "secret") {
+			t.Fatalf("Secret in history: %v", j)
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 )
 
 type GUID struct {
+	ref         *Reference // See reference.go
 	value       string
 	parent      Value
 	path        string
@@ -24,6 +25,11 @@ func (obj *GUID) SetNew() error {
 	return obj.SetValue(uuid.NewV4().String())
 }
 
+// Reference returns what the GUID refers to, or nil if it is not a reference
+func (obj *GUID) Reference() *Reference {
+	return obj.ref
+}
+
 func (obj *GUID) Value() string {
 	return obj.value
 }
@@ -31,8 +37,9 @@ func (obj *GUID) Value() string {
 func (obj *GUID) SetValue(val string) error {
 	if val == "" {
 		if val != obj.value {
+			changingValue(obj)
 			obj.value = val
-			Root.changedValue(obj)
+			changedValue(obj)
 		}
 	} else {
 		guid, err := uuid.FromString(val)
@@ -42,8 +49,17 @@ func (obj *GUID) SetValue(val string) error {
 		if guid.String() == obj.value {
 			return nil
 		}
+		if s := storeOf(obj); s != nil && obj.ref != nil {
+			if err := s.checkReference(obj.ref, guid.String()); err != nil {
+				return err
+			}
+		}
+		if err := checkUnique(obj, guid.String()); err != nil {
+			return err
+		}
+		changingValue(obj)
 		obj.value = guid.String()
-		Root.changedValue(obj)
+		changedValue(obj)
 	}
 	return nil
 }
@@ -53,7 +69,6 @@ func (obj *GUID) WriteGroups() []string {
 }
 func (obj *GUID) AddWriteGroup(group ...string) {
 	obj.writeGroups = mergeGroups(obj.writeGroups, group)
-	obj.readGroups = mergeGroups(obj.readGroups, group)
 }
 func (obj *GUID) ReadGroups() []string {
 	return obj.readGroups
@@ -74,7 +89,15 @@ func (obj *GUID) String() string         { return fmt.Sprintf("%q", obj.value) }
 func (obj *GUID) SetParentAndPath(parent Value, path string) {
 	obj.parent = parent
 	obj.path = path
-	Root.changedValue(obj)
+	if s := storeOf(obj); s != nil && obj.ref != nil {
+		s.addReference(obj)
+	}
+	changedValue(obj)
+}
+
+func (obj *GUID) snapshot() func() {
+	value, revision := obj.value, obj.revision
+	return func() { obj.value, obj.revision = value, revision }
 }
 
 func (obj *GUID) JSON(skipSave bool) json.Value {
@@ -84,9 +107,8 @@ func (obj *GUID) JSON(skipSave bool) json.Value {
 func (obj *GUID) SetJSON(j json.Value) error {
 	switch j := j.(type) {
 	case *json.String:
-		obj.SetValue(j.Get())
+		return obj.SetValue(j.Get())
 	default:
 		return errInvalidJSONType(j, json.StringValue)
 	}
-	return nil
 }
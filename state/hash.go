@@ -11,6 +11,7 @@ type Hash struct {
 	initializer func() Value
 	values      map[string]Value
 	isIDObject  bool
+	indexes     map[string]*index // See index.go
 	parent      Value
 	path        string
 	revision    uint64
@@ -20,7 +21,7 @@ type Hash struct {
 	readGroups  []string
 }
 
-func NewHashOf(initializer func() Value) func() Value {
+func NewHashOf(initializer func() Value, indexes ...IndexDef) func() Value {
 	isIDObject := false
 	if testObj, ok := initializer().(*Object); ok {
 		if len(testObj.Definition.Values) > 0 {
@@ -35,10 +36,14 @@ func NewHashOf(initializer func() Value) func() Value {
 	}
 
 	return func() Value {
-		return &Hash{initializer: initializer, isIDObject: isIDObject}
+		h := &Hash{initializer: initializer, isIDObject: isIDObject}
+		h.addIndexes(indexes)
+		return h
 	}
 }
 
+// init makes the map before the first element is added.  Reading a nil map
+// is fine, so only changes call it and readers holding RLock never write.
 func (obj *Hash) init() {
 	if obj.values != nil {
 		return
@@ -64,6 +69,9 @@ func (obj *Hash) NewElement(key string, jValue json.Value) (Value, error) {
 		if err := elem.SetJSON(jValue); err != nil {
 			return nil, err
 		}
+		if err := validate(elem); err != nil {
+			return nil, err
+		}
 	}
 
 	if obj.isIDObject {
@@ -87,29 +95,80 @@ func (obj *Hash) NewElement(key string, jValue json.Value) (Value, error) {
 		}
 	}
 
-	if obj.parent != nil {
-		elem.SetParentAndPath(obj, fmt.Sprintf("%v[%v]", obj.path, key))
+	if err := obj.checkIndexes(key, elem); err != nil {
+		return nil, err
 	}
-	obj.init()
-	if oldValue, ok := obj.values[key]; ok {
-		oldValue.SetParentAndPath(nil, "")
+
+	err := atomic(obj, func() error {
+		changingValue(obj)
+		if obj.parent != nil {
+			elem.SetParentAndPath(obj, fmt.Sprintf("%v[%v]", obj.path, key))
+		}
+		obj.init()
+		if oldValue, ok := obj.values[key]; ok {
+			oldValue.SetParentAndPath(nil, "")
+		}
+		obj.values[key] = elem
+		changedValue(obj)
+
+		// References in elem were set before it had a store to check them with
+		if s := storeOf(elem); s != nil {
+			return s.checkReferences(elem)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	obj.values[key] = elem
-	Root.changedValue(obj)
 	return elem, nil
 }
 
-func (obj *Hash) Clear() {
-	for _, value := range obj.values {
-		value.SetParentAndPath(nil, "")
+// Clear removes every element.  Like Delete, references to them are handled
+// as set by their OnDelete.
+func (obj *Hash) Clear() error {
+	return atomic(obj, func() error {
+		changingValue(obj)
+		values := obj.values
+		for _, value := range values {
+			value.SetParentAndPath(nil, "")
+		}
+		obj.values = make(map[string]Value)
+		obj.rebuildIndexes()
+		changedValue(obj)
+		if s := storeOf(obj); s != nil {
+			for key := range values {
+				if err := s.removeReferences(obj, key); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Delete removes the element at key.  If the hash is in the root, references
+// to the element are handled as set by their OnDelete, see reference.go.
+func (obj *Hash) Delete(key string) error {
+	value, ok := obj.values[key]
+	if !ok {
+		return errUnknownKey(key)
 	}
-	obj.values = nil
-	Root.changedValue(obj)
+
+	return atomic(obj, func() error {
+		changingValue(obj)
+		value.SetParentAndPath(nil, "")
+		delete(obj.values, key)
+		obj.unindex(key)
+		changedValue(obj)
+		if s := storeOf(obj); s != nil {
+			return s.removeReferences(obj, key)
+		}
+		return nil
+	})
 }
 
 func (obj *Hash) Keys() []string {
 	var ret []string
-	obj.init()
 	for key, _ := range obj.values {
 		ret = append(ret, key)
 	}
@@ -118,7 +177,6 @@ func (obj *Hash) Keys() []string {
 
 func (obj *Hash) Values() []Value {
 	var ret []Value
-	obj.init()
 	for _, value := range obj.values {
 		ret = append(ret, value)
 	}
@@ -126,7 +184,6 @@ func (obj *Hash) Values() []Value {
 }
 
 func (obj *Hash) Get(key string) Value {
-	obj.init()
 	return obj.values[key]
 }
 
@@ -135,7 +192,6 @@ func (obj *Hash) WriteGroups() []string {
 }
 func (obj *Hash) AddWriteGroup(group ...string) {
 	obj.writeGroups = mergeGroups(obj.writeGroups, group)
-	obj.readGroups = mergeGroups(obj.readGroups, group)
 }
 func (obj *Hash) ReadGroups() []string {
 	return obj.readGroups
@@ -153,7 +209,6 @@ func (obj *Hash) Path() string           { return obj.path }
 func (obj *Hash) Parent() Value          { return obj.parent }
 func (obj *Hash) String() string {
 	var children []string
-	obj.init()
 	for key, value := range obj.values {
 		children = append(children, fmt.Sprintf("%v: %v", key, value.String()))
 	}
@@ -164,7 +219,6 @@ func (obj *Hash) SetParentAndPath(parent Value, path string) {
 	obj.parent = parent
 	obj.path = path
 
-	obj.init()
 	for key, value := range obj.values {
 		if parent != nil {
 			value.SetParentAndPath(obj, fmt.Sprintf("%v[%v]", obj.path, key))
@@ -172,12 +226,46 @@ func (obj *Hash) SetParentAndPath(parent Value, path string) {
 			value.SetParentAndPath(nil, "")
 		}
 	}
-	Root.changedValue(obj)
+	if s := storeOf(obj); s != nil && obj.indexes != nil {
+		s.addIndexed(obj)
+	}
+	changedValue(obj)
+}
+
+// elements returns a copy of the key to element map
+func (obj *Hash) elements() map[string]Value {
+	elems := make(map[string]Value, len(obj.values))
+	for key, value := range obj.values {
+		elems[key] = value
+	}
+	return elems
+}
+
+func (obj *Hash) snapshot() func() {
+	values := make(map[string]Value)
+	for key, value := range obj.values {
+		values[key] = value
+	}
+	revision := obj.revision
+	return func() {
+		for key, value := range obj.values {
+			if values[key] != value {
+				value.SetParentAndPath(nil, "")
+			}
+		}
+		obj.values = values
+		obj.revision = revision
+		obj.rebuildIndexes()
+		for key, value := range obj.values {
+			if obj.parent != nil && value.Parent() != obj {
+				value.SetParentAndPath(obj, fmt.Sprintf("%v[%v]", obj.path, key))
+			}
+		}
+	}
 }
 
 func (obj *Hash) JSON(skipSave bool) json.Value {
 	j := make(json.Object)
-	obj.init()
 	for key, value := range obj.values {
 		if !skipSave || !value.SkipSave() {
 			j[key] = value.JSON(skipSave)
@@ -192,17 +280,18 @@ func (obj *Hash) SetJSON(j json.Value) error {
 		return errInvalidJSONType(j, json.ObjectValue)
 	}
 
-	obj.init()
-	for key, jValue := range jObject {
-		if val := obj.Get(key); val != nil {
-			if err := val.SetJSON(jValue); err != nil {
-				return err
-			}
-		} else {
-			if _, err := obj.NewElement(key, jValue); err != nil {
-				return err
+	return atomic(obj, func() error {
+		for key, jValue := range jObject {
+			if val := obj.Get(key); val != nil {
+				if err := val.SetJSON(jValue); err != nil {
+					return err
+				}
+			} else {
+				if _, err := obj.NewElement(key, jValue); err != nil {
+					return err
+				}
 			}
 		}
-	}
-	return nil
+		return nil
+	})
 }
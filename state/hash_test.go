@@ -0,0 +1,42 @@
+package state
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestHashConcurrentReaders(t *testing.T) {
+	const readers = 4
+	s, h := newTestStore(t)
+	s.SetHistorySize(0) // History reads the hash while it is locked
+
+	for i := 0; i < 10; i++ {
+		s.Lock()
+		if i%2 == 0 {
+			h.Clear()
+		} else {
+			elem, _ := h.NewEmptyElement("")
+			elem.(*Object).Get("Name").(*String).SetValue("x")
+		}
+		s.Unlock()
+
+		// Every reader holds RLock before any of them reads
+		var ready, done sync.WaitGroup
+		ready.Add(readers)
+		done.Add(readers)
+		for r := 0; r < readers; r++ {
+			go func() {
+				defer done.Done()
+				s.RLock()
+				defer s.RUnlock()
+				ready.Done()
+				ready.Wait()
+				h.Get("")
+				h.Keys()
+				h.Values()
+				h.JSON(false)
+			}()
+		}
+		done.Wait()
+	}
+}
@@ -0,0 +1,387 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/rollerderby/go/json"
+)
+
+var (
+	errNothingToUndo   = errors.New("Nothing to undo")
+	errNothingToRedo   = errors.New("Nothing to redo")
+	errPendingChanges  = errors.New("Cannot undo or redo with changes pending in this lock")
+	defaultHistorySize = 100
+)
+
+// Change is the JSON of a path before and after a ChangeSet.  Old or New is
+// nil if the path did not exist.  Elements added to or removed from a hash
+// are changes of their own path, not of the whole hash.
+type Change struct {
+	Path string
+	Old  json.Value
+	New  json.Value
+
+	value  Value
+	parent Value // The hash value was added to or removed from
+}
+
+func (c Change) Value() Value { return c.value }
+
+// ChangeSet holds everything changed during one Lock/Unlock cycle
+type ChangeSet struct {
+	Revision uint64
+	User     string
+	Time     time.Time
+	Changes  []Change
+
+	undo   []func()
+	redo   []func()
+	values []Value
+}
+
+// Values returns every value that Undo or Redo of cs will change
+func (cs *ChangeSet) Values() []Value {
+	return append([]Value(nil), cs.values...)
+}
+
+func (cs *ChangeSet) JSON() json.Value {
+	return cs.FilteredJSON(func(Change) bool { return true })
+}
+
+// FilteredJSON is like JSON but only includes the changes keep returns true for
+func (cs *ChangeSet) FilteredJSON(keep func(Change) bool) json.Value {
+	var changes []Change
+	for _, c := range cs.Changes {
+		if keep(c) {
+			changes = append(changes, c)
+		}
+	}
+	return cs.changesJSON(changes)
+}
+
+// ReadableJSON is like JSON but only includes what m may read
+func (cs *ChangeSet) ReadableJSON(m Member) json.Value {
+	var changes []Change
+	for _, c := range cs.Changes {
+		if c.parent != nil && !(CanRead(c.parent, m) && canReadOne(c.value, m)) {
+			continue
+		} else if c.parent == nil && !CanRead(c.value, m) {
+			continue
+		}
+		if c.Old != nil {
+			c.Old = ReadableSnapshot(c.value, c.Old, m)
+		}
+		if c.New != nil {
+			c.New = ReadableSnapshot(c.value, c.New, m)
+		}
+		changes = append(changes, c)
+	}
+	return cs.changesJSON(changes)
+}
+
+func (cs *ChangeSet) changesJSON(changes []Change) json.Value {
+	var jChanges json.Array
+	for _, c := range changes {
+		obj := make(json.Object)
+		obj["Path"] = json.NewString(c.Path)
+		if c.Old != nil {
+			obj["Old"] = c.Old
+		}
+		if c.New != nil {
+			obj["New"] = c.New
+		}
+		jChanges = append(jChanges, obj)
+	}
+
+	obj := make(json.Object)
+	obj["Revision"] = json.NewNumber(int64(cs.Revision))
+	obj["User"] = json.NewString(cs.User)
+	obj["Time"] = json.NewString(cs.Time.Format(time.RFC3339))
+	obj["Changes"] = jChanges
+	return obj
+}
+
+type historyEntry struct {
+	value Value
+	path  string
+	old   json.Value       // Not kept for a hash, which records elems instead
+	elems map[string]Value // The elements of a hash
+	undo  func()
+}
+
+// SetUser records who is making the changes in the current lock.  It is
+// cleared by Unlock.
+func (r *Store) SetUser(user string) {
+	r.user = user
+}
+
+// SetHistorySize sets how many change sets are kept for Undo.  0 turns the
+// history off.
+func (r *Store) SetHistorySize(size int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.historySize = size
+	if len(r.history) > size {
+		r.history = r.history[len(r.history)-size:]
+	}
+	r.redo = nil
+}
+
+// History returns the recorded change sets, oldest first
+func (r *Store) History() []*ChangeSet {
+	return append([]*ChangeSet(nil), r.history...)
+}
+
+// NextUndo returns the change set Undo would revert, or nil
+func (r *Store) NextUndo() *ChangeSet {
+	if len(r.history) == 0 {
+		return nil
+	}
+	return r.history[len(r.history)-1]
+}
+
+// NextRedo returns the change set Redo would apply, or nil
+func (r *Store) NextRedo() *ChangeSet {
+	if len(r.redo) == 0 {
+		return nil
+	}
+	return r.redo[len(r.redo)-1]
+}
+
+// Undo reverts the most recent change set.  The lock must be held and
+// nothing else may have been changed in this lock.
+func (r *Store) Undo() (*ChangeSet, error) {
+	if len(r.cycle) > 0 {
+		return nil, errPendingChanges
+	}
+	if len(r.history) == 0 {
+		return nil, errNothingToUndo
+	}
+
+	cs := r.history[len(r.history)-1]
+	r.history = r.history[:len(r.history)-1]
+	r.redo = append(r.redo, cs)
+
+	r.replay(cs, true)
+	r.writeAudit(r.replayedChangeSet(cs), r.remoteAddr, true)
+	return cs, nil
+}
+
+// Redo applies the change set most recently reverted by Undo
+func (r *Store) Redo() (*ChangeSet, error) {
+	if len(r.cycle) > 0 {
+		return nil, errPendingChanges
+	}
+	if len(r.redo) == 0 {
+		return nil, errNothingToRedo
+	}
+
+	cs := r.redo[len(r.redo)-1]
+	r.redo = r.redo[:len(r.redo)-1]
+	r.history = append(r.history, cs)
+
+	r.replay(cs, false)
+	r.writeAudit(r.replayedChangeSet(cs), r.remoteAddr, false)
+	return cs, nil
+}
+
+func (r *Store) replay(cs *ChangeSet, undo bool) {
+	r.restoring = true
+	if undo {
+		for i := len(cs.undo) - 1; i >= 0; i-- {
+			cs.undo[i]()
+		}
+	} else {
+		for _, redo := range cs.redo {
+			redo()
+		}
+	}
+	r.restoring = false
+	r.rebuildIndexes()
+
+	// Mark everything restored as changed so it is sent out and saved
+	r.replaying = true
+	for _, value := range cs.values {
+		if value.Parent() == nil {
+			continue
+		}
+		r.changedValue(value)
+		for _, child := range Children(value) {
+			r.changedValue(child)
+		}
+	}
+	r.replaying = false
+}
+
+// replayedChangeSet is cs as replayed by the current user in this lock
+func (r *Store) replayedChangeSet(cs *ChangeSet) *ChangeSet {
+	return &ChangeSet{Revision: r.revision, User: r.user, Time: time.Now(), Changes: cs.Changes}
+}
+
+// recordingHistory reports if changes are recorded, for the history or the
+// audit log
+func (r *Store) recordingHistory() bool {
+	return r.isReady && (r.historySize > 0 || r.auditing()) && !r.replaying
+}
+
+// recordHistory remembers value as it was before its first change in this
+// lock.  Computed values are left out, recompute rebuilds them after an Undo
+// or Redo, and nobody may write them.
+func (r *Store) recordHistory(value Value, s snapshotter) {
+	if !r.recordingHistory() {
+		return
+	}
+	if _, ok := value.(*Computed); ok {
+		return
+	}
+	if r.cycleTouched == nil {
+		r.cycleTouched = make(map[Value]bool)
+	}
+	if r.cycleTouched[value] {
+		return
+	}
+	r.cycleTouched[value] = true
+
+	entry := &historyEntry{value: value, path: value.Path(), undo: s.snapshot()}
+	if hash, ok := value.(*Hash); ok {
+		entry.elems = hash.elements()
+	} else {
+		entry.old = value.JSON(false)
+	}
+	r.cycle = append(r.cycle, entry)
+}
+
+// trimHistory forgets the values first touched after the cycle had n
+// entries, as a rollback put them back
+func (r *Store) trimHistory(n int) {
+	if n >= len(r.cycle) {
+		return
+	}
+	for _, entry := range r.cycle[n:] {
+		delete(r.cycleTouched, entry.value)
+	}
+	r.cycle = r.cycle[:n]
+}
+
+// commitHistory turns the values touched in this lock into a ChangeSet
+func (r *Store) commitHistory() {
+	cycle := r.cycle
+	r.cycle = nil
+	r.cycleTouched = nil
+	user, remoteAddr := r.user, r.remoteAddr
+	r.user, r.remoteAddr = "", ""
+	if len(cycle) == 0 {
+		return
+	}
+
+	cs := &ChangeSet{Revision: r.revision, User: user, Time: time.Now()}
+	var changes []Change
+	for _, entry := range cycle {
+		cs.undo = append(cs.undo, entry.undo)
+		cs.values = append(cs.values, entry.value)
+	}
+	for _, entry := range cycle {
+		if entry.value.Parent() != nil {
+			cs.redo = append(cs.redo, entry.value.(snapshotter).snapshot())
+		}
+		if hash, ok := entry.value.(*Hash); ok {
+			changes = append(changes, elementChanges(hash, entry)...)
+			continue
+		}
+
+		var newJSON json.Value
+		if entry.value.Parent() != nil {
+			newJSON = entry.value.JSON(false)
+		}
+		if newJSON != nil && newJSON.JSON(false) == entry.old.JSON(false) {
+			continue
+		}
+		changes = append(changes, Change{Path: entry.path, Old: entry.old, New: newJSON, value: entry.value})
+	}
+	cs.Changes = uncoveredChanges(changes)
+	if len(cs.Changes) == 0 {
+		return
+	}
+
+	r.writeAudit(cs, remoteAddr, false)
+	if r.historySize <= 0 {
+		return
+	}
+	r.history = append(r.history, cs)
+	if len(r.history) > r.historySize {
+		r.history = r.history[len(r.history)-r.historySize:]
+	}
+	r.redo = nil
+}
+
+// elementChanges returns a change for each element of hash added, removed or
+// replaced since entry was recorded
+func elementChanges(hash *Hash, entry *historyEntry) []Change {
+	if hash.Parent() == nil {
+		// Removed along with whatever held it
+		return nil
+	}
+	elems := hash.elements()
+	var keys []string
+	for key, old := range entry.elems {
+		if elems[key] != old {
+			keys = append(keys, key)
+		}
+	}
+	for key, elem := range elems {
+		if _, ok := entry.elems[key]; !ok && elem != nil {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var ret []Change
+	for _, key := range keys {
+		old, elem := entry.elems[key], elems[key]
+		c := Change{Path: fmt.Sprintf("%v[%v]", entry.path, key), value: elem, parent: hash}
+		if old != nil {
+			c.Old = old.JSON(false)
+		}
+		if elem != nil {
+			c.New = elem.JSON(false)
+		} else {
+			c.value = old
+		}
+		ret = append(ret, c)
+	}
+	return ret
+}
+
+// uncoveredChanges leaves out the changes inside a value that has a change of
+// its own, as that already holds them
+func uncoveredChanges(changes []Change) []Change {
+	paths := make(map[string]bool)
+	for _, c := range changes {
+		paths[c.Path] = true
+	}
+
+	var ret []Change
+	for _, c := range changes {
+		keys, err := SplitPath(c.Path)
+		covered := false
+		for idx, prefix := 1, ""; err == nil && idx < len(keys); idx++ {
+			if idx == 1 {
+				prefix = keys[0]
+			} else {
+				prefix = fmt.Sprintf("%v[%v]", prefix, keys[idx-1])
+			}
+			if paths[prefix] {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			ret = append(ret, c)
+		}
+	}
+	return ret
+}
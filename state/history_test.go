@@ -0,0 +1,86 @@
+package state
+
+import (
+	"errors"
+	"testing"
+)
+
+func newTestStore(t *testing.T) (*Store, *Hash) {
+	s := NewStore(t.TempDir())
+	h := NewHashOf(newSecretObject)().(*Hash)
+	s.Lock()
+	s.Add("Things", "", h)
+	s.isReady = true
+	s.Unlock()
+	return s, h
+}
+
+func TestHistoryElementChanges(t *testing.T) {
+	s, h := newTestStore(t)
+
+	s.Lock()
+	first, _ := h.NewEmptyElement("")
+	s.Unlock()
+	s.Lock()
+	second, _ := h.NewEmptyElement("")
+	s.Unlock()
+	s.Lock()
+	h.Delete(first.(*Object).Get("ID").(*GUID).Value())
+	second.(*Object).Get("Name").(*String).SetValue("b")
+	s.Unlock()
+
+	s.RLock()
+	defer s.RUnlock()
+	history := s.History()
+	if len(history) != 3 {
+		t.Fatalf("Expected 3 change sets, got %v", len(history))
+	}
+	added := history[1].Changes
+	if len(added) != 1 || added[0].Path != second.Path() || added[0].Old != nil || added[0].New == nil {
+		t.Fatalf("Unexpected changes adding an element: %v", history[1].JSON().JSON(false))
+	}
+	changed := history[2].Changes
+	if len(changed) != 2 {
+		t.Fatalf("Unexpected changes: %v", history[2].JSON().JSON(false))
+	}
+	for _, c := range changed {
+		switch c.Path {
+		case second.Path() + "[Name]":
+			if c.New.JSON(false) != `"b"` {
+				t.Fatalf("Unexpected name change: %v", history[2].JSON().JSON(false))
+			}
+		default:
+			if c.New != nil || c.Old == nil {
+				t.Fatalf("Unexpected delete: %v", history[2].JSON().JSON(false))
+			}
+		}
+	}
+}
+
+func TestHistoryRollback(t *testing.T) {
+	s, h := newTestStore(t)
+
+	s.Lock()
+	elem, _ := h.NewEmptyElement("")
+	s.Unlock()
+	name := elem.(*Object).Get("Name").(*String)
+
+	s.Lock()
+	s.Transaction(func() error {
+		name.SetValue("rolled back")
+		return errors.New("fail")
+	})
+	if _, err := s.Undo(); err != nil {
+		t.Fatalf("Rolled back changes block undo: %v", err)
+	}
+	s.Unlock()
+
+	s.RLock()
+	defer s.RUnlock()
+	if len(h.Keys()) != 0 {
+		t.Fatalf("Undo did not remove the element: %v", h.JSON(false).JSON(false))
+	}
+	if n := len(s.History()); n != 0 {
+		t.Fatalf("Expected no history, got %v", n)
+	}
+}
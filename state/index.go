@@ -0,0 +1,238 @@
+package state
+
+import (
+	"sort"
+	"strings"
+)
+
+// A Hash of Objects can index string fields (String, GUID or Enum) of its
+// elements so FindBy doesn't have to look at every element.  Indexes are
+// kept up to date by changedValue, and are rebuilt after a rollback or undo
+// since those restore values without calling changedValue.  Like references,
+// unique indexes are not checked while configs are loading.
+
+type IndexDef struct {
+	Field  string
+	Unique bool // Only one element may have each non-empty value
+}
+
+type index struct {
+	IndexDef
+	values map[string]map[string]bool // Field value -> hash keys
+	keys   map[string]string          // Hash key -> field value
+}
+
+type stringValue interface {
+	Value() string
+}
+
+func newIndex(def IndexDef) *index {
+	return &index{IndexDef: def, values: make(map[string]map[string]bool), keys: make(map[string]string)}
+}
+
+func (idx *index) set(key, val string) {
+	if old, ok := idx.keys[key]; ok {
+		if old == val {
+			return
+		}
+		idx.remove(key)
+	}
+	idx.keys[key] = val
+	if idx.values[val] == nil {
+		idx.values[val] = make(map[string]bool)
+	}
+	idx.values[val][key] = true
+}
+
+func (idx *index) remove(key string) {
+	old, ok := idx.keys[key]
+	if !ok {
+		return
+	}
+	delete(idx.keys, key)
+	delete(idx.values[old], key)
+	if len(idx.values[old]) == 0 {
+		delete(idx.values, old)
+	}
+}
+
+// conflict returns true if an element other than key has val
+func (idx *index) conflict(key, val string) bool {
+	if !idx.Unique || val == "" {
+		return false
+	}
+	for other := range idx.values[val] {
+		if other != key {
+			return true
+		}
+	}
+	return false
+}
+
+func fieldValue(elem Value, field string) (string, bool) {
+	obj, ok := elem.(*Object)
+	if !ok {
+		return "", false
+	}
+	val, ok := obj.Get(field).(stringValue)
+	if !ok {
+		return "", false
+	}
+	return val.Value(), true
+}
+
+func (obj *Hash) addIndexes(defs []IndexDef) {
+	for _, def := range defs {
+		if obj.indexes == nil {
+			obj.indexes = make(map[string]*index)
+		}
+		obj.indexes[def.Field] = newIndex(def)
+	}
+}
+
+// keyOf returns the key of elem, an element of the hash
+func (obj *Hash) keyOf(elem Value) (string, bool) {
+	if obj.parent != nil {
+		prefix := obj.path + "["
+		if p := elem.Path(); strings.HasPrefix(p, prefix) && strings.HasSuffix(p, "]") {
+			return p[len(prefix) : len(p)-1], true
+		}
+	}
+	for key, value := range obj.values {
+		if value == elem {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// checkIndexes returns an error if elem would break a unique index at key
+func (obj *Hash) checkIndexes(key string, elem Value) error {
+	if !obj.checksUnique() {
+		return nil
+	}
+	for field, idx := range obj.indexes {
+		if val, ok := fieldValue(elem, field); ok && idx.conflict(key, val) {
+			return errDuplicate(obj.path, field, val)
+		}
+	}
+	return nil
+}
+
+func (obj *Hash) indexElement(elem Value) {
+	key, ok := obj.keyOf(elem)
+	if !ok {
+		return
+	}
+	for field, idx := range obj.indexes {
+		if val, ok := fieldValue(elem, field); ok {
+			idx.set(key, val)
+		}
+	}
+}
+
+func (obj *Hash) unindex(key string) {
+	for _, idx := range obj.indexes {
+		idx.remove(key)
+	}
+}
+
+func (obj *Hash) rebuildIndexes() {
+	for field, idx := range obj.indexes {
+		*idx = *newIndex(idx.IndexDef)
+		for key, elem := range obj.values {
+			if val, ok := fieldValue(elem, field); ok {
+				idx.set(key, val)
+			}
+		}
+	}
+}
+
+// FindBy returns the elements whose field is val, sorted by key.  field must
+// be indexed.
+func (obj *Hash) FindBy(field, val string) []Value {
+	idx := obj.indexes[field]
+	if idx == nil {
+		log.Errorf("FindBy on %v without an index on %v", obj.path, field)
+		return nil
+	}
+	var keys []string
+	for key := range idx.values[val] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var ret []Value
+	for _, key := range keys {
+		ret = append(ret, obj.values[key])
+	}
+	return ret
+}
+
+// indexedHash returns the hash holding the index for value, a field of one
+// of its elements, along with the field name and the element key
+func indexedHash(value Value) (*Hash, string, string) {
+	elem, ok := value.Parent().(*Object)
+	if !ok {
+		return nil, "", ""
+	}
+	hash, ok := elem.Parent().(*Hash)
+	if !ok || hash.indexes == nil {
+		return nil, "", ""
+	}
+	for _, def := range elem.Definition.Values {
+		if elem.Get(def.Name) == value {
+			if hash.indexes[def.Name] == nil {
+				return nil, "", ""
+			}
+			key, _ := hash.keyOf(elem)
+			return hash, def.Name, key
+		}
+	}
+	return nil, "", ""
+}
+
+// checksUnique is false for a hash that isn't in a store, or while the
+// store's configs are loading
+func (obj *Hash) checksUnique() bool {
+	s := storeOf(obj)
+	return s != nil && s.isReady
+}
+
+// checkUnique is called before value is set to val
+func checkUnique(value Value, val string) error {
+	hash, field, key := indexedHash(value)
+	if hash != nil && hash.checksUnique() && hash.indexes[field].conflict(key, val) {
+		return errDuplicate(hash.path, field, val)
+	}
+	return nil
+}
+
+// updateIndexes is called by changedValue
+func (r *Store) updateIndexes(value Value) {
+	if hash, ok := value.Parent().(*Hash); ok && hash.indexes != nil {
+		hash.indexElement(value)
+	} else if hash, _, _ := indexedHash(value); hash != nil {
+		hash.indexElement(value.Parent())
+	}
+}
+
+// addIndexed registers hash with the store it was added to.  Hashes removed
+// from the store are dropped the next time the store looks.
+func (r *Store) addIndexed(hash *Hash) {
+	if r.indexed == nil {
+		r.indexed = make(map[*Hash]bool)
+	}
+	r.indexed[hash] = true
+}
+
+// rebuildIndexes is called after values are restored
+func (r *Store) rebuildIndexes() {
+	for hash := range r.indexed {
+		if storeOf(hash) != r {
+			delete(r.indexed, hash)
+			continue
+		}
+		hash.rebuildIndexes()
+	}
+}
@@ -0,0 +1,131 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/rollerderby/go/json"
+)
+
+// newIndexedStore is newTestStore with a unique index on Name
+func newIndexedStore(t *testing.T) (*Store, *Hash) {
+	s := NewStore(t.TempDir())
+	h := NewHashOf(newSecretObject, IndexDef{Field: "Name", Unique: true})().(*Hash)
+	s.Lock()
+	s.Add("Things", "", h)
+	s.isReady = true
+	s.Unlock()
+	return s, h
+}
+
+func checkFindBy(t *testing.T, h *Hash, name string, expected ...Value) {
+	t.Helper()
+	found := h.FindBy("Name", name)
+	if len(found) != len(expected) {
+		t.Fatalf("FindBy %q found %v elements, expected %v", name, len(found), len(expected))
+	}
+	for idx := range found {
+		if found[idx] != expected[idx] {
+			t.Fatalf("FindBy %q found %v, expected %v", name, found[idx].Path(), expected[idx].Path())
+		}
+	}
+}
+
+func TestIndexUnique(t *testing.T) {
+	s, h := newIndexedStore(t)
+	s.Lock()
+	defer s.Unlock()
+
+	thing := func(id string) json.Object {
+		return json.Object{"ID": json.NewString(id), "Name": json.NewString("a"), "Secret": json.NewString("")}
+	}
+	first, err := h.NewElement("", thing("6ba7b810-9dad-11d1-80b4-00c04fd430c8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.NewElement("", thing("6ba7b811-9dad-11d1-80b4-00c04fd430c8")); err == nil {
+		t.Fatal("NewElement accepted a duplicate Name")
+	}
+	second, _ := h.NewEmptyElement("")
+	third, _ := h.NewEmptyElement("")
+	if err := second.(*Object).Get("Name").(*String).SetValue("a"); err == nil {
+		t.Fatal("SetValue accepted a duplicate Name")
+	}
+	checkFindBy(t, h, "a", first)
+	if n := len(h.FindBy("Name", "")); n != 2 {
+		t.Fatalf("Expected 2 elements without a Name, got %v", n)
+	}
+
+	// Setting an element to its own value is not a duplicate
+	if err := first.(*Object).Get("Name").(*String).SetValue("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := third.(*Object).Get("Name").SetJSON(json.NewString("c")); err != nil {
+		t.Fatal(err)
+	}
+	checkFindBy(t, h, "c", third)
+}
+
+func TestIndexFieldChange(t *testing.T) {
+	s, h := newIndexedStore(t)
+	s.Lock()
+	defer s.Unlock()
+
+	elem, _ := h.NewEmptyElement("")
+	name := elem.(*Object).Get("Name").(*String)
+	name.SetValue("a")
+	checkFindBy(t, h, "a", elem)
+
+	name.SetValue("b")
+	checkFindBy(t, h, "a")
+	checkFindBy(t, h, "b", elem)
+
+	// The old value is free for another element
+	other, _ := h.NewEmptyElement("")
+	if err := other.(*Object).Get("Name").(*String).SetValue("a"); err != nil {
+		t.Fatal(err)
+	}
+	checkFindBy(t, h, "a", other)
+
+	h.Delete(elem.(*Object).Get("ID").(*GUID).Value())
+	checkFindBy(t, h, "b")
+}
+
+func TestIndexRebuild(t *testing.T) {
+	s, h := newIndexedStore(t)
+	s.Lock()
+	elem, _ := h.NewEmptyElement("")
+	name := elem.(*Object).Get("Name").(*String)
+	name.SetValue("a")
+	s.Unlock()
+	s.Lock()
+	name.SetValue("b")
+	s.Unlock()
+
+	s.Lock()
+	defer s.Unlock()
+	if _, err := s.Undo(); err != nil {
+		t.Fatal(err)
+	}
+	checkFindBy(t, h, "a", elem)
+	checkFindBy(t, h, "b")
+
+	other, _ := h.NewEmptyElement("")
+	otherName := other.(*Object).Get("Name").(*String)
+	if err := otherName.SetValue("a"); err == nil {
+		t.Fatal("SetValue accepted a Name restored by Undo")
+	}
+	if err := otherName.SetValue("b"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A rollback puts the index back too
+	err := s.Transaction(func() error {
+		otherName.SetValue("c")
+		return errReadOnly
+	})
+	if err == nil {
+		t.Fatal("Expected the transaction to fail")
+	}
+	checkFindBy(t, h, "b", other)
+	checkFindBy(t, h, "c")
+}
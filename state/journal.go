@@ -0,0 +1,326 @@
+package state
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path"
+
+	"github.com/rollerderby/go/json"
+)
+
+// The journal is an append-only file in the config folder with one line per
+// Unlock that changed anything saved.  Each line holds the full JSON of every
+// changed save unit (a root value, or an element of a hash backed root):
+//
+//	{"Revision": 12, "Values": [{"Root": "Teams", "Key": "...", "Value": {...}}]}
+//
+// Elements removed from a hash are written as {"Root", "Key", "Deleted": true}.
+//
+// It is written before Unlock returns, replayed over the config files on
+// startup and emptied once everything in it is written to the files.
+// LoadSavedConfigs opens it once it has loaded and replayed everything.
+
+type saveUnit struct {
+	name  string
+	key   string
+	value Value // nil if key was deleted
+}
+
+func (r *Store) journalFilename() string {
+	return path.Join(r.configDir(), "journal.json")
+}
+
+func (r *Store) openJournal() error {
+	if r.journal != nil {
+		r.journal.Close()
+		r.journal = nil
+	}
+
+	filename := r.journalFilename()
+	if err := os.MkdirAll(path.Dir(filename), 0775); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0664)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	r.journal = f
+	r.journalSize = fi.Size()
+	for _, rv := range r.values {
+		if hash, ok := rv.value.(*Hash); ok && rv.backingFile != "" {
+			rv.journalKeys = hashKeys(hash)
+		}
+	}
+	return nil
+}
+
+func hashKeys(hash *Hash) map[string]bool {
+	keys := make(map[string]bool)
+	for _, key := range hash.Keys() {
+		keys[key] = true
+	}
+	return keys
+}
+
+// changedUnits returns the save units holding the values changed in this lock
+func (r *Store) changedUnits() []*saveUnit {
+	var ret []*saveUnit
+	found := make(map[Value]bool)
+	for _, value := range r.changedValues {
+		// Find the value just below the root, and the one below that
+		var top, second Value
+		for v := value; v != nil && v != Value(r); v = v.Parent() {
+			second, top = top, v
+		}
+		if top == nil {
+			continue
+		}
+		rv := r.values[top.Path()]
+		if rv == nil || rv.backingFile == "" || rv.value != top {
+			continue
+		}
+
+		unit := &saveUnit{name: top.Path(), value: top}
+		if hash, ok := top.(*Hash); ok {
+			if second == nil {
+				// The keys changed, new elements are journaled on their own
+				if !found[top] {
+					found[top] = true
+					ret = append(ret, deletedUnits(rv, hash)...)
+				}
+				continue
+			}
+			for _, key := range hash.Keys() {
+				if hash.Get(key) == second {
+					unit.key = key
+					break
+				}
+			}
+			unit.value = second
+		}
+		if unit.value.SkipSave() || found[unit.value] {
+			continue
+		}
+		found[unit.value] = true
+		ret = append(ret, unit)
+	}
+	return ret
+}
+
+// deletedUnits returns a unit for each key removed from hash since the last
+// journal entry
+func deletedUnits(rv *rootValue, hash *Hash) []*saveUnit {
+	keys := hashKeys(hash)
+	var ret []*saveUnit
+	for key := range rv.journalKeys {
+		if !keys[key] {
+			ret = append(ret, &saveUnit{name: hash.Path(), key: key})
+		}
+	}
+	rv.journalKeys = keys
+	return ret
+}
+
+// writeJournal is called by Unlock
+func (r *Store) writeJournal() {
+	if r.skipJournal {
+		r.skipJournal = false
+		return
+	}
+	if r.journal == nil || !r.changed {
+		return
+	}
+	units := r.changedUnits()
+	if len(units) == 0 {
+		return
+	}
+
+	var values json.Array
+	for _, unit := range units {
+		obj := make(json.Object)
+		obj["Root"] = json.NewString(unit.name)
+		if unit.key != "" {
+			obj["Key"] = json.NewString(unit.key)
+		}
+		if unit.value == nil {
+			obj["Deleted"] = json.True
+		} else {
+			obj["Value"] = r.stamp(unit.name, unit.value.JSON(true))
+		}
+		values = append(values, obj)
+	}
+	entry := make(json.Object)
+	entry["Revision"] = json.NewNumber(int64(r.revision))
+	entry["Values"] = values
+
+	line := []byte(entry.JSON(false) + "\n")
+	if _, err := r.journal.Write(line); err != nil {
+		log.Errorf("Cannot write journal: %v", err)
+		return
+	}
+	if err := r.journal.Sync(); err != nil {
+		log.Errorf("Cannot sync journal: %v", err)
+	}
+	r.journalSize += int64(len(line))
+}
+
+// truncateJournal drops the first size bytes of the journal once the entries
+// in them are saved, keeping any written after
+func (r *Store) truncateJournal(size int64) {
+	if r.journal == nil || size == 0 {
+		return
+	}
+	if size >= r.journalSize {
+		if err := r.journal.Truncate(0); err != nil {
+			log.Errorf("Cannot truncate journal: %v", err)
+			return
+		}
+		r.journalSize = 0
+		return
+	}
+
+	filename := r.journalFilename()
+	f, err := os.Open(filename)
+	if err != nil {
+		log.Errorf("Cannot truncate journal: %v", err)
+		return
+	}
+	rest := make([]byte, r.journalSize-size)
+	_, err = f.ReadAt(rest, size)
+	f.Close()
+	if err == nil {
+		err = writeFileAtomic(filename, rest, 0664)
+	}
+	if err != nil {
+		log.Errorf("Cannot truncate journal: %v", err)
+		return
+	}
+
+	// The old file was replaced, so appends go to the new one
+	if f, err = os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0664); err != nil {
+		log.Errorf("Cannot reopen journal, changes not yet saved can be lost in a crash: %v", err)
+		r.journal.Close()
+		r.journal = nil
+		return
+	}
+	r.journal.Close()
+	r.journal = f
+	r.journalSize = int64(len(rest))
+}
+
+// replayJournal applies any journal left behind by a crash.  The lock must be
+// held.
+func (r *Store) replayJournal() []error {
+	var errors []error
+
+	f, err := os.Open(r.journalFilename())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return append(errors, err)
+	}
+	defer f.Close()
+
+	lines := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		jValue, err := json.Decode(line)
+		entry, ok := jValue.(json.Object)
+		if err != nil || !ok {
+			// Most likely the last line was cut short by the crash
+			log.Errorf("Stopping journal replay at unreadable entry %v", lines+1)
+			break
+		}
+		lines++
+
+		values, _ := entry["Values"].(json.Array)
+		for _, jUnit := range values {
+			if err := r.replayUnit(jUnit); err != nil {
+				errors = append(errors, err)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		errors = append(errors, err)
+	}
+	if lines > 0 {
+		log.Noticef("Replayed %v journal entries", lines)
+	}
+	return errors
+}
+
+func (r *Store) replayUnit(jUnit json.Value) error {
+	obj, ok := jUnit.(json.Object)
+	if !ok {
+		return errInvalidJSONType(jUnit, json.ObjectValue)
+	}
+	name, _ := obj["Root"].(*json.String)
+	key, _ := obj["Key"].(*json.String)
+	jValue := obj["Value"]
+	deleted := obj["Deleted"] == json.True
+	if name == nil || (jValue == nil && !deleted) {
+		return errInvalidJSONValue(obj, errNoKey)
+	}
+
+	rv := r.values[name.Get()]
+	if rv == nil {
+		return errUnknownRoot(name.Get())
+	}
+	if jValue != nil {
+		keyStr := ""
+		if key != nil {
+			keyStr = key.Get()
+		}
+		var err error
+		if jValue, _, err = r.migrate(name.Get(), keyStr, jValue, nil); err != nil {
+			return err
+		}
+	}
+
+	hash, isHash := rv.value.(*Hash)
+	if deleted {
+		if !isHash || key == nil {
+			return errInvalidJSONValue(obj, errNoKey)
+		}
+		if hash.Get(key.Get()) == nil {
+			return nil
+		}
+		return hash.Delete(key.Get())
+	}
+	if !isHash {
+		if err := rv.value.SetJSON(jValue); err != nil {
+			return err
+		}
+		rv.value.SetSaveNeeded(true)
+		return nil
+	}
+
+	if key == nil {
+		return errInvalidJSONValue(obj, errNoKey)
+	}
+	value := hash.Get(key.Get())
+	if value != nil {
+		if err := value.SetJSON(jValue); err != nil {
+			return err
+		}
+	} else {
+		var err error
+		if value, err = hash.NewElement(key.Get(), jValue); err != nil {
+			return err
+		}
+	}
+	value.SetSaveNeeded(true)
+	return nil
+}
@@ -0,0 +1,104 @@
+package state
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestJournalKeepsUnsavedEntries(t *testing.T) {
+	s := NewStore(t.TempDir())
+	h := NewHashOf(newSecretObject)().(*Hash)
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	s.Lock()
+	s.Add("Things", "things", h)
+	if err := s.openJournal(); err != nil {
+		t.Fatal(err)
+	}
+	elem, _ := h.NewEmptyElement("")
+	name := elem.(*Object).Get("Name").(*String)
+	s.Unlock()
+
+	s.Lock()
+	p, err := s.snapshotSave()
+	s.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Changed while p is being written
+	s.Lock()
+	name.SetValue("unsaved")
+	s.Unlock()
+
+	err = p.write()
+	s.Lock()
+	if err := s.finishSave(p, err); err != nil {
+		t.Fatal(err)
+	}
+	s.Unlock()
+
+	lines := func() [][]byte {
+		data, err := ioutil.ReadFile(s.journalFilename())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+	}
+	if l := lines(); len(l) != 1 || !bytes.Contains(l[0], []byte("unsaved")) {
+		t.Fatalf("Expected only the unsaved entry, got %q", l)
+	}
+
+	s.Lock()
+	name.SetValue("later")
+	s.Unlock()
+	if l := lines(); len(l) != 2 {
+		t.Fatalf("Expected 2 entries, got %q", l)
+	}
+	s.Lock()
+	s.closeStorage()
+	s.journal.Close()
+	s.Unlock()
+}
+
+func TestJournalBeforeSaveLoop(t *testing.T) {
+	dir := t.TempDir()
+	newStore := func() (*Store, *Hash) {
+		s := NewStore(dir)
+		h := NewHashOf(newSecretObject)().(*Hash)
+		s.Lock()
+		s.Add("Things", "things", h)
+		s.Unlock()
+		if err := s.LoadSavedConfigs(); err != nil {
+			t.Fatal(err)
+		}
+		return s, h
+	}
+
+	s, h := newStore()
+	s.Lock()
+	elem, _ := h.NewEmptyElement("")
+	elem.(*Object).Get("Name").(*String).SetValue("journaled")
+	s.Unlock()
+	key := elem.(*Object).Get("ID").(*GUID).Value()
+
+	// Crash without saving, the next start replays the journal
+	s.saveMu.Lock()
+	s.Lock()
+	s.closeStorage()
+	s.journal.Close()
+	s.Unlock()
+	s.saveMu.Unlock()
+
+	s, h = newStore()
+	s.RLock()
+	elem = h.Get(key)
+	s.RUnlock()
+	if elem == nil || elem.(*Object).Get("Name").(*String).Value() != "journaled" {
+		t.Fatalf("Change made before SaveLoop was lost: %v", h.JSON(false).JSON(false))
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,106 @@
+package state
+
+import (
+	"github.com/rollerderby/go/json"
+)
+
+// SchemaVersionKey is added to every saved object so the loader knows which
+// migrations the file still needs.  Files saved before versioning are
+// version 0.  Only object values carry a version, anything else is always
+// treated as current.
+const SchemaVersionKey = "_SchemaVersion"
+
+// Migration upgrades one saved object of a root by a single schema version.
+// For a hash, key is the element's key.
+type Migration func(key string, j json.Object) (json.Object, error)
+
+// AddMigration registers the step that upgrades a root's saved files from
+// version from to from+1.  Steps must be added in order, before
+// LoadSavedConfigs.
+func (r *Store) AddMigration(name string, from int, m Migration) error {
+	if r.values[name] == nil {
+		return errUnknownRoot(name)
+	}
+	if from != len(r.migrations[name]) {
+		return errSchemaVersion(name, from, len(r.migrations[name]))
+	}
+	if r.migrations == nil {
+		r.migrations = make(map[string][]Migration)
+	}
+	r.migrations[name] = append(r.migrations[name], m)
+	return nil
+}
+
+// SchemaVersion is the version a root's files are saved with
+func (r *Store) SchemaVersion(name string) int {
+	return len(r.migrations[name])
+}
+
+// stamp returns j with the root's schema version added
+func (r *Store) stamp(name string, j json.Value) json.Value {
+	obj, ok := j.(json.Object)
+	if !ok {
+		return j
+	}
+	ret := make(json.Object, len(obj)+1)
+	for k, v := range obj {
+		ret[k] = v
+	}
+	ret[SchemaVersionKey] = json.NewNumber(int64(r.SchemaVersion(name)))
+	return ret
+}
+
+// migrate strips the schema version from a loaded value and runs any
+// migrations it still needs.  The bool is true if the value was migrated and
+// has to be saved again.  beforeMigrate, if not nil, is called before the first
+// migration is run.
+func (r *Store) migrate(name, key string, j json.Value, beforeMigrate func() error) (json.Value, bool, error) {
+	obj, ok := j.(json.Object)
+	if !ok {
+		return j, false, nil
+	}
+
+	from := 0
+	if jVersion, ok := obj[SchemaVersionKey]; ok {
+		num, ok := jVersion.(*json.Number)
+		if !ok {
+			return nil, false, errInvalidJSONType(jVersion, json.NumberValue)
+		}
+		version, err := num.GetInt64()
+		if err != nil {
+			return nil, false, errInvalidJSONValue(jVersion, err)
+		}
+		from = int(version)
+	}
+	steps := r.migrations[name]
+	if from < 0 || from > len(steps) {
+		return nil, false, errSchemaVersion(name, from, len(steps))
+	}
+
+	if _, ok := obj[SchemaVersionKey]; ok {
+		stripped := make(json.Object, len(obj))
+		for k, v := range obj {
+			if k != SchemaVersionKey {
+				stripped[k] = v
+			}
+		}
+		obj = stripped
+	}
+	if from == len(steps) {
+		return obj, false, nil
+	}
+
+	if beforeMigrate != nil {
+		if err := beforeMigrate(); err != nil {
+			return nil, false, err
+		}
+	}
+	for version := from; version < len(steps); version++ {
+		var err error
+		if obj, err = steps[version](key, obj); err != nil {
+			return nil, false, errMigration(name, key, version, err)
+		}
+	}
+	log.Infof("Migrated %v[%v] from schema version %v to %v", name, key, from, len(steps))
+	return obj, true, nil
+}
@@ -0,0 +1,118 @@
+package state
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/rollerderby/go/json"
+)
+
+// renameTitle is a migration from a schema where Name was called Title
+func renameTitle(key string, j json.Object) (json.Object, error) {
+	title, ok := j["Title"]
+	if !ok {
+		return nil, errors.New("No Title")
+	}
+	delete(j, "Title")
+	j["Name"] = title
+	return j, nil
+}
+
+func TestAddMigration(t *testing.T) {
+	s, _ := newTestStore(t)
+	noop := func(key string, j json.Object) (json.Object, error) { return j, nil }
+
+	if _, ok := s.AddMigration("Missing", 0, noop).(ErrUnknownRoot); !ok {
+		t.Fatal("Expected ErrUnknownRoot")
+	}
+	if _, ok := s.AddMigration("Things", 1, noop).(ErrSchemaVersion); !ok {
+		t.Fatal("Expected ErrSchemaVersion for a step out of order")
+	}
+	if err := s.AddMigration("Things", 0, noop); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddMigration("Things", 1, noop); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.AddMigration("Things", 1, noop).(ErrSchemaVersion); !ok {
+		t.Fatal("Expected ErrSchemaVersion for a step added twice")
+	}
+	if v := s.SchemaVersion("Things"); v != 2 {
+		t.Fatalf("Expected version 2, got %v", v)
+	}
+}
+
+func TestMigrateOnLoad(t *testing.T) {
+	dir := t.TempDir()
+	thingsDir := path.Join(dir, "config", "things")
+	if err := os.MkdirAll(thingsDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"6ba7b810-9dad-11d1-80b4-00c04fd430c8": `{"ID": "6ba7b810-9dad-11d1-80b4-00c04fd430c8", "Title": "old", "Secret": ""}`,
+		"6ba7b811-9dad-11d1-80b4-00c04fd430c8": `{"ID": "6ba7b811-9dad-11d1-80b4-00c04fd430c8", "Name": "current", "Secret": "", "_SchemaVersion": 1}`,
+		"6ba7b812-9dad-11d1-80b4-00c04fd430c8": `{"ID": "6ba7b812-9dad-11d1-80b4-00c04fd430c8", "Name": "future", "Secret": "", "_SchemaVersion": 2}`,
+	}
+	for key, data := range files {
+		if err := ioutil.WriteFile(path.Join(thingsDir, key+".json"), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := NewStore(dir)
+	h := NewHashOf(newSecretObject)().(*Hash)
+	s.Lock()
+	s.Add("Things", "things", h)
+	s.Unlock()
+	t.Cleanup(func() { s.Close() })
+	if err := s.AddMigration("Things", 0, renameTitle); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.LoadSavedConfigs(); err != nil {
+		t.Fatal(err)
+	}
+
+	s.RLock()
+	old, current := h.Get("6ba7b810-9dad-11d1-80b4-00c04fd430c8"), h.Get("6ba7b811-9dad-11d1-80b4-00c04fd430c8")
+	future := h.Get("6ba7b812-9dad-11d1-80b4-00c04fd430c8")
+	s.RUnlock()
+	if old == nil || old.(*Object).Get("Name").(*String).Value() != "old" {
+		t.Fatalf("Version 0 file not migrated: %v", h.JSON(false).JSON(false))
+	}
+	if current == nil || current.(*Object).Get("Name").(*String).Value() != "current" {
+		t.Fatalf("Current file not loaded: %v", h.JSON(false).JSON(false))
+	}
+	if future != nil {
+		t.Fatal("Loaded a file from a newer schema version")
+	}
+
+	// The files are backed up as they were before migrating
+	backups, err := s.Backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 {
+		t.Fatalf("Expected 1 backup, got %v", len(backups))
+	}
+	data, err := ioutil.ReadFile(path.Join(s.backupDir(), backups[0].Name, "things", "6ba7b810-9dad-11d1-80b4-00c04fd430c8.json"))
+	if err != nil || !strings.Contains(string(data), "Title") {
+		t.Fatalf("Backup is not the unmigrated file: %s %v", data, err)
+	}
+
+	// The migrated element is saved again, stamped with the current version
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	j, err := loadJSON(path.Join(thingsDir, "6ba7b810-9dad-11d1-80b4-00c04fd430c8.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := j.(json.Object)
+	if obj[SchemaVersionKey].JSON(false) != "1" || obj["Name"].JSON(false) != `"old"` || obj["Title"] != nil {
+		t.Fatalf("Migrated file saved as %v", j.JSON(false))
+	}
+}
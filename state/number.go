@@ -7,6 +7,7 @@ import (
 )
 
 type Number struct {
+	def         *NumberDef
 	value       int64
 	parent      Value
 	path        string
@@ -17,27 +18,62 @@ type Number struct {
 	readGroups  []string
 }
 
+// NumberDef limits the values a Number accepts and sets its initial value
+type NumberDef struct {
+	Min     *int64
+	Max     *int64
+	Default int64
+}
+
 func NewNumber() Value { return &Number{} }
 
+func NewNumberOf(def NumberDef) func() Value {
+	return func() Value {
+		return &Number{def: &def, value: def.Default}
+	}
+}
+
+// Int64 returns a pointer to val, for NumberDef.Min and Max
+func Int64(val int64) *int64 { return &val }
+
 func (obj *Number) Value() int64 {
 	return obj.value
 }
 
 func (obj *Number) SetValue(val int64) error {
+	if err := obj.check(val); err != nil {
+		return err
+	}
 	if obj.value == val {
 		return nil
 	}
+	changingValue(obj)
 	obj.value = val
-	Root.changedValue(obj)
+	changedValue(obj)
 	return nil
 }
 
+// check returns an ErrConstraint if val breaks the NumberDef
+func (obj *Number) check(val int64) error {
+	if obj.def == nil {
+		return nil
+	}
+	if obj.def.Min != nil && val < *obj.def.Min {
+		return errConstraint(obj, val, fmt.Sprintf("less than %v", *obj.def.Min))
+	}
+	if obj.def.Max != nil && val > *obj.def.Max {
+		return errConstraint(obj, val, fmt.Sprintf("more than %v", *obj.def.Max))
+	}
+	return nil
+}
+
+func (obj *Number) validate() error { return obj.check(obj.value) }
+
 func (obj *Number) WriteGroups() []string {
 	return obj.writeGroups
 }
 func (obj *Number) AddWriteGroup(group ...string) {
 	obj.writeGroups = mergeGroups(obj.writeGroups, group)
-	obj.readGroups = mergeGroups(obj.readGroups, group)
 }
 func (obj *Number) ReadGroups() []string {
 	return obj.readGroups
@@ -58,7 +94,12 @@ func (obj *Number) String() string         { return fmt.Sprint(obj.value) }
 func (obj *Number) SetParentAndPath(parent Value, path string) {
 	obj.parent = parent
 	obj.path = path
-	Root.changedValue(obj)
+	changedValue(obj)
+}
+
+func (obj *Number) snapshot() func() {
+	value, revision := obj.value, obj.revision
+	return func() { obj.value, obj.revision = value, revision }
 }
 
 func (obj *Number) JSON(skipSave bool) json.Value {
@@ -71,16 +112,15 @@ func (obj *Number) SetJSON(j json.Value) error {
 		if val, err := j.AsNumber().GetInt64(); err != nil {
 			return errInvalidJSONValue(j, err)
 		} else {
-			obj.SetValue(val)
+			return obj.SetValue(val)
 		}
 	case *json.Number:
 		if val, err := j.GetInt64(); err != nil {
 			return errInvalidJSONValue(j, err)
 		} else {
-			obj.SetValue(val)
+			return obj.SetValue(val)
 		}
 	default:
 		return errInvalidJSONType(j, json.NumberValue, conversionTypes, json.StringValue)
 	}
-	return nil
 }
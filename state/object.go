@@ -15,6 +15,9 @@ type ObjectDef struct {
 type ObjectValueDef struct {
 	Name        string
 	Initializer func() Value
+	ReadGroups  []string
+	WriteGroups []string
+	Secret      bool // Never sent to clients or written to the audit log
 }
 
 type Object struct {
@@ -31,6 +34,8 @@ type Object struct {
 	readGroups      []string
 }
 
+// init makes the values on first use.  SetParentAndPath calls it while the
+// lock is held, so an object in a store is never changed by its readers.
 func (obj *Object) init() {
 	if obj.values != nil {
 		return
@@ -38,21 +43,42 @@ func (obj *Object) init() {
 
 	obj.values = make(map[string]Value)
 	for _, value := range obj.Definition.Values {
-		obj.values[value.Name] = value.Initializer()
+		val := value.Initializer()
+		val.AddReadGroup(value.ReadGroups...)
+		val.AddWriteGroup(value.WriteGroups...)
+		obj.values[value.Name] = val
 	}
 }
 
+// isSecret reports if the value named key is a Secret
+func (obj *Object) isSecret(key string) bool {
+	for _, def := range obj.Definition.Values {
+		if def.Name == key {
+			return def.Secret
+		}
+	}
+	return false
+}
+
 func (obj *Object) Get(key string) Value {
 	obj.init()
 	return obj.values[key]
 }
 
+func (obj *Object) Values() []Value {
+	var ret []Value
+	obj.init()
+	for _, value := range obj.Definition.Values {
+		ret = append(ret, obj.values[value.Name])
+	}
+	return ret
+}
+
 func (obj *Object) WriteGroups() []string {
 	return obj.writeGroups
 }
 func (obj *Object) AddWriteGroup(group ...string) {
 	obj.writeGroups = mergeGroups(obj.writeGroups, group)
-	obj.readGroups = mergeGroups(obj.readGroups, group)
 }
 func (obj *Object) ReadGroups() []string {
 	return obj.readGroups
@@ -89,7 +115,13 @@ func (obj *Object) SetParentAndPath(parent Value, path string) {
 			value.SetParentAndPath(nil, "")
 		}
 	}
-	Root.changedValue(obj)
+	changedValue(obj)
+}
+
+func (obj *Object) snapshot() func() {
+	// The children are fixed by Definition and snapshot themselves
+	revision := obj.revision
+	return func() { obj.revision = revision }
 }
 
 func (obj *Object) JSON(skipSave bool) json.Value {
@@ -114,7 +146,8 @@ func (obj *Object) SetJSON(j json.Value) error {
 	var missingKeys, extraKeys []string
 	if !obj.AllowPartialSet {
 		for _, value := range obj.Definition.Values {
-			if _, ok := object[value.Name]; !ok {
+			// Values that are not saved will be missing from config files
+			if _, ok := object[value.Name]; !ok && !obj.values[value.Name].SkipSave() {
 				missingKeys = append(missingKeys, value.Name)
 			}
 		}
@@ -129,13 +162,19 @@ func (obj *Object) SetJSON(j json.Value) error {
 	if len(missingKeys) > 0 || len(extraKeys) > 0 {
 		return errObjectKeys(j, missingKeys, extraKeys)
 	}
-	for _, value := range obj.Definition.Values {
-		if jValue, ok := object[value.Name]; ok {
-			if err := obj.values[value.Name].SetJSON(jValue); err != nil {
-				return err
+	return atomic(obj, func() error {
+		changingValue(obj)
+		for _, value := range obj.Definition.Values {
+			if _, ok := obj.values[value.Name].(*Computed); ok {
+				continue
+			}
+			if jValue, ok := object[value.Name]; ok {
+				if err := obj.values[value.Name].SetJSON(jValue); err != nil {
+					return err
+				}
 			}
 		}
-	}
-	Root.changedValue(obj)
-	return nil
+		changedValue(obj)
+		return nil
+	})
 }
@@ -0,0 +1,124 @@
+package state
+
+import (
+	"sort"
+
+	"github.com/rollerderby/go/json"
+)
+
+// Patch watchers get an RFC 6902 JSON Patch for every Unlock that changed
+// anything, turning the JSON(false) of the whole store before the lock into
+// the JSON after it.  Paths are JSON Pointers, so "Teams[id][Name]" is
+// "/Teams/id/Name".  The patch holds every value, read groups are not
+// checked.
+
+// WatchPatch registers f to be called once per Unlock with the revision and
+// the patch for that lock.  The lock must be held.  The returned id can be
+// passed to Unwatch.
+func (r *Store) WatchPatch(f func(rev uint64, patch json.Patch)) uint64 {
+	if r.patchBase == nil {
+		r.patchBase = r.JSON(false).(json.Object)
+	}
+
+	r.watchMu.Lock()
+	defer r.watchMu.Unlock()
+
+	r.watchID++
+	r.watchers = append(r.watchers, &watcher{id: r.watchID, patch: f})
+	return r.watchID
+}
+
+// PathPointer turns a state path into a JSON Pointer
+func PathPointer(path string) (string, error) {
+	keys, err := SplitPath(path)
+	if err != nil {
+		return "", err
+	}
+	pointer := ""
+	for _, key := range keys {
+		pointer += "/" + json.EscapePointer(key)
+	}
+	return pointer, nil
+}
+
+// buildPatch is called by notifyWatchers with the values changed in this
+// lock.  Roots are diffed whole, but only the changed elements of a hash are.
+func (r *Store) buildPatch(changed []Value) json.Patch {
+	units := make(map[string]map[string]bool) // root name -> hash keys, "" for the whole root
+	for _, value := range changed {
+		var top, second Value
+		for v := value; v != nil && v != Value(r); v = v.Parent() {
+			second, top = top, v
+		}
+		if top == nil || top.Parent() != Value(r) {
+			continue
+		}
+		name := top.Path()
+		if units[name] == nil {
+			units[name] = make(map[string]bool)
+		}
+		key := ""
+		if hash, ok := top.(*Hash); ok && second != nil {
+			key, _ = hash.keyOf(second)
+		}
+		units[name][key] = true
+	}
+
+	var names []string
+	for name := range units {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var patch json.Patch
+	for _, name := range names {
+		keys := units[name]
+		value := r.Get(name)
+		hash, isHash := value.(*Hash)
+		baseHash, _ := r.patchBase[name].(json.Object)
+		if keys[""] || !isHash || baseHash == nil {
+			newJSON := value.JSON(false)
+			patch = appendDiff(patch, "/"+json.EscapePointer(name), r.patchBase[name], newJSON)
+			r.patchBase[name] = newJSON
+			continue
+		}
+
+		var sorted []string
+		for key := range keys {
+			sorted = append(sorted, key)
+		}
+		sort.Strings(sorted)
+		for _, key := range sorted {
+			pointer := "/" + json.EscapePointer(name) + "/" + json.EscapePointer(key)
+			var newJSON json.Value
+			if elem := hash.Get(key); elem != nil {
+				newJSON = elem.JSON(false)
+			}
+			patch = appendDiff(patch, pointer, baseHash[key], newJSON)
+			if newJSON == nil {
+				delete(baseHash, key)
+			} else {
+				baseHash[key] = newJSON
+			}
+		}
+	}
+	return patch
+}
+
+// appendDiff adds the operations turning from into to at pointer.  from or to
+// is nil if there was nothing there.
+func appendDiff(patch json.Patch, pointer string, from, to json.Value) json.Patch {
+	switch {
+	case from == nil && to == nil:
+		return patch
+	case from == nil:
+		return append(patch, &json.Operation{Op: "add", Path: pointer, Value: json.Copy(to)})
+	case to == nil:
+		return append(patch, &json.Operation{Op: "remove", Path: pointer})
+	}
+	for _, op := range json.Diff(from, to) {
+		op.Path = pointer + op.Path
+		patch = append(patch, op)
+	}
+	return patch
+}
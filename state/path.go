@@ -0,0 +1,127 @@
+package state
+
+import (
+	"strconv"
+
+	"github.com/rollerderby/go/json"
+)
+
+// Paths name a value from the root down, one key per level:
+// "Ruleset[id][Rules][Clock.Jam.Name]".  Bare keys may also be separated by
+// dots, so "Teams[*].Name" is "Teams[*][Name]".  A key of "*" in a query
+// matches every child.
+
+const Wildcard = "*"
+
+// SplitPath breaks path into its keys
+func SplitPath(path string) ([]string, error) {
+	var keys []string
+	for i := 0; i < len(path); {
+		if path[i] == '[' {
+			end := i + 1
+			for end < len(path) && path[end] != ']' {
+				end++
+			}
+			if end == len(path) {
+				return nil, errInvalidPath(path)
+			}
+			keys = append(keys, path[i+1:end])
+			i = end + 1
+			continue
+		}
+
+		if path[i] == '.' {
+			if i == 0 || i == len(path)-1 {
+				return nil, errInvalidPath(path)
+			}
+			i++
+		}
+		end := i
+		for end < len(path) && path[end] != '[' && path[end] != '.' && path[end] != ']' {
+			end++
+		}
+		if end == i {
+			return nil, errInvalidPath(path)
+		}
+		keys = append(keys, path[i:end])
+		i = end
+	}
+	return keys, nil
+}
+
+// Child returns the child of a container value named key, or nil
+func Child(value Value, key string) Value {
+	switch value := value.(type) {
+	case *Store:
+		return value.Get(key)
+	case *Object:
+		return value.Get(key)
+	case *Hash:
+		return value.Get(key)
+	case *Array:
+		idx, err := strconv.Atoi(key)
+		values := value.Values()
+		if err != nil || idx < 0 || idx >= len(values) {
+			return nil
+		}
+		return values[idx]
+	}
+	return nil
+}
+
+// Find returns the value at path or nil if there is none.  Wildcards are not
+// expanded, use Query for those.
+func (r *Store) Find(path string) Value {
+	keys, err := SplitPath(path)
+	if err != nil {
+		return nil
+	}
+	var value Value = r
+	for _, key := range keys {
+		if value = Child(value, key); value == nil {
+			return nil
+		}
+	}
+	return value
+}
+
+// Query returns every value matching pattern
+func (r *Store) Query(pattern string) ([]Value, error) {
+	keys, err := SplitPath(pattern)
+	if err != nil {
+		return nil, err
+	}
+	values := []Value{r}
+	for _, key := range keys {
+		var next []Value
+		for _, value := range values {
+			if key == Wildcard {
+				next = append(next, Children(value)...)
+			} else if child := Child(value, key); child != nil {
+				next = append(next, child)
+			}
+		}
+		values = next
+	}
+	return values, nil
+}
+
+// SetPath sets every value matching pattern from j.  Either all of them are
+// set or, on error, none are.
+func (r *Store) SetPath(pattern string, j json.Value) error {
+	values, err := r.Query(pattern)
+	if err != nil {
+		return err
+	}
+	if len(values) == 0 {
+		return errUnknownPath(pattern)
+	}
+	return r.Transaction(func() error {
+		for _, value := range values {
+			if err := value.SetJSON(j); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
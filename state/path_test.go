@@ -0,0 +1,91 @@
+package state
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/rollerderby/go/json"
+)
+
+func TestSplitPath(t *testing.T) {
+	for _, c := range []struct {
+		path string
+		keys []string
+	}{
+		{"Teams", []string{"Teams"}},
+		{"Teams[a][Name]", []string{"Teams", "a", "Name"}},
+		{"Teams[*].Name", []string{"Teams", "*", "Name"}},
+		{"Ruleset[id][Rules][Clock.Jam.Name]", []string{"Ruleset", "id", "Rules", "Clock.Jam.Name"}},
+		{"Teams[a", nil},
+		{"Teams.", nil},
+		{".Teams", nil},
+		{"Teams..Name", nil},
+	} {
+		keys, err := SplitPath(c.path)
+		if c.keys == nil {
+			if err == nil {
+				t.Errorf("SplitPath(%q) = %q, expected an error", c.path, keys)
+			}
+		} else if err != nil || !reflect.DeepEqual(keys, c.keys) {
+			t.Errorf("SplitPath(%q) = %q, %v", c.path, keys, err)
+		}
+	}
+}
+
+func TestFindAndQuery(t *testing.T) {
+	s, h := newTestStore(t)
+	s.Lock()
+	first, _ := h.NewEmptyElement("")
+	second, _ := h.NewEmptyElement("")
+	s.Unlock()
+
+	s.RLock()
+	name := first.(*Object).Get("Name")
+	if got := s.Find(first.Path() + ".Name"); got != name {
+		t.Fatalf("Find returned %v", got)
+	}
+	if got := s.Find(first.Path() + "[Missing]"); got != nil {
+		t.Fatalf("Find of a missing key returned %v", got)
+	}
+	values, err := s.Query("Things[*][Name]")
+	s.RUnlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 2 {
+		t.Fatalf("Expected 2 matches, got %v", values)
+	}
+	for _, v := range values {
+		if v != name && v != second.(*Object).Get("Name") {
+			t.Fatalf("Unexpected match %v", v.Path())
+		}
+	}
+}
+
+func TestSetPath(t *testing.T) {
+	s, h := newTestStore(t)
+	s.Lock()
+	defer s.Unlock()
+	first, _ := h.NewEmptyElement("")
+	second, _ := h.NewEmptyElement("")
+
+	if err := s.SetPath("Things[*].Name", json.NewString("all")); err != nil {
+		t.Fatal(err)
+	}
+	for _, elem := range []Value{first, second} {
+		if got := elem.(*Object).Get("Name").(*String).Value(); got != "all" {
+			t.Fatalf("%v not set: %q", elem.Path(), got)
+		}
+	}
+
+	// All or nothing
+	if err := s.SetPath("Things[*]", json.Object{"Name": json.NewString("x")}); err == nil {
+		t.Fatal("SetPath accepted an object with missing keys")
+	}
+	if err := s.SetPath("Things[missing][Name]", json.NewString("x")); err == nil {
+		t.Fatal("SetPath accepted an unknown path")
+	}
+	if got := first.(*Object).Get("Name").(*String).Value(); got != "all" {
+		t.Fatalf("Failed SetPath changed a value: %q", got)
+	}
+}
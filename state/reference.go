@@ -0,0 +1,134 @@
+package state
+
+// A GUID made with NewGUIDRef refers to an element of a hash in the root.
+// Setting it to a key that does not exist fails, and deleting the element
+// it refers to restricts, cascades or nullifies as set by OnDelete.  While
+// configs are loading (before SetIsReady) references are not checked, and
+// RestoreBackup checks them all once every root is loaded.
+
+type RefAction int
+
+const (
+	RefRestrict RefAction = iota // The element cannot be deleted
+	RefCascade                   // The element holding the reference is deleted too
+	RefNullify                   // The reference is cleared
+)
+
+type Reference struct {
+	Root     string
+	OnDelete RefAction
+}
+
+func NewGUIDRef(root string, onDelete RefAction) func() Value {
+	return func() Value {
+		return &GUID{ref: &Reference{Root: root, OnDelete: onDelete}}
+	}
+}
+
+// addReference registers obj with the store it was added to.  References
+// removed from the store are dropped the next time the store looks.
+func (r *Store) addReference(obj *GUID) {
+	if r.refs == nil {
+		r.refs = make(map[*GUID]bool)
+	}
+	r.refs[obj] = true
+}
+
+func (r *Store) checkReference(ref *Reference, key string) error {
+	if !r.isReady || r.deferRefs || key == "" {
+		return nil
+	}
+	if hash, ok := r.Get(ref.Root).(*Hash); ok && hash.Get(key) != nil {
+		return nil
+	}
+	return errInvalidReference(ref.Root, key)
+}
+
+// checkReferences checks every reference at or below value, which was set
+// before it was added to the store
+func (r *Store) checkReferences(value Value) error {
+	var err error
+	Walk(value, func(v Value) bool {
+		if obj, ok := v.(*GUID); ok && obj.ref != nil {
+			err = r.checkReference(obj.ref, obj.value)
+		}
+		return err == nil
+	})
+	return err
+}
+
+// checkAllReferences checks every reference in the store
+func (r *Store) checkAllReferences() error {
+	for _, value := range r.values {
+		if err := r.checkReferences(value.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReferencesTo returns every GUID referring to key in the root hash rootName
+func (r *Store) ReferencesTo(rootName, key string) []*GUID {
+	var ret []*GUID
+	for obj := range r.refs {
+		if storeOf(obj) != r {
+			delete(r.refs, obj)
+			continue
+		}
+		if obj.ref.Root == rootName && obj.value == key {
+			ret = append(ret, obj)
+		}
+	}
+	return ret
+}
+
+// removeReferences applies OnDelete for the references to key, which was just
+// deleted from hash
+func (r *Store) removeReferences(hash *Hash, key string) error {
+	if !r.isReady || r.deferRefs || hash.Parent() != Value(r) {
+		return nil
+	}
+	refs := r.ReferencesTo(hash.Path(), key)
+	for _, obj := range refs {
+		if obj.ref.OnDelete == RefRestrict {
+			return errRestricted(hash.Path(), key, obj.Path())
+		}
+	}
+	for _, obj := range refs {
+		if obj.Parent() == nil {
+			// Already removed by an earlier cascade
+			continue
+		}
+		var err error
+		if obj.ref.OnDelete == RefCascade {
+			err = removeElement(obj)
+		} else {
+			err = obj.SetValue("")
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeElement removes the nearest ancestor of value held in a Hash or Array
+func removeElement(value Value) error {
+	for v := value; v.Parent() != nil; v = v.Parent() {
+		switch parent := v.Parent().(type) {
+		case *Hash:
+			for _, key := range parent.Keys() {
+				if parent.Get(key) == v {
+					return parent.Delete(key)
+				}
+			}
+		case *Array:
+			for idx, elem := range parent.Values() {
+				if elem == v {
+					return parent.Remove(idx)
+				}
+			}
+		}
+	}
+	return errNotImplemented
+}
@@ -0,0 +1,65 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/rollerderby/go/json"
+)
+
+func newRefObject() Value {
+	return &Object{Definition: ObjectDef{Name: "Ref", Values: []ObjectValueDef{
+		{Name: "ID", Initializer: NewGUID},
+		{Name: "Thing", Initializer: NewGUIDRef("Things", RefCascade)},
+	}}}
+}
+
+func TestHashClearReferences(t *testing.T) {
+	s, targets := newTestStore(t)
+	refs := NewHashOf(newRefObject)().(*Hash)
+	s.Lock()
+	defer s.Unlock()
+	s.Add("Refs", "", refs)
+	target, _ := targets.NewEmptyElement("")
+	ref, _ := refs.NewEmptyElement("")
+	if err := ref.(*Object).Get("Thing").(*GUID).SetValue(target.(*Object).Get("ID").(*GUID).Value()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := targets.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(refs.Keys()); n != 0 {
+		t.Fatalf("Clear did not cascade, %v refs left", n)
+	}
+}
+
+func TestArrayElementReferences(t *testing.T) {
+	s, targets := newTestStore(t)
+	refs := NewArrayOf(NewGUIDRef("Things", RefRestrict))().(*Array)
+	s.Lock()
+	defer s.Unlock()
+	s.Add("Refs", "", refs)
+	target, _ := targets.NewEmptyElement("")
+	key := target.(*Object).Get("ID").(*GUID).Value()
+	missing := "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+
+	if _, err := refs.NewElement(json.NewString(missing)); err == nil {
+		t.Fatal("NewElement accepted a reference to a missing element")
+	}
+	if _, err := refs.Insert(0, json.NewString(missing)); err == nil {
+		t.Fatal("Insert accepted a reference to a missing element")
+	}
+	if err := refs.SetJSON(json.Array{json.NewString(key), json.NewString(missing)}); err == nil {
+		t.Fatal("SetJSON accepted a reference to a missing element")
+	}
+	if n := len(refs.Values()); n != 0 {
+		t.Fatalf("Expected no elements, got %v", n)
+	}
+
+	if _, err := refs.NewElement(json.NewString(key)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := refs.Insert(0, json.NewString(key)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,176 @@
+package state
+
+import "github.com/rollerderby/go/json"
+
+// Reloader is implemented by storage that can be changed from outside the
+// server, like config files edited by hand.  SaveLoop polls it and merges
+// the changes into the store.
+type Reloader interface {
+	// Changes returns what changed since it was last loaded, saved or
+	// returned by Changes.  values and hashes are the names of the roots
+	// that aren't and are hashes.  A deleted file has a nil Value.
+	Changes(values, hashes []string) ([]StorageChange, []error)
+	// Retry makes the next Changes return change again, as it could not be
+	// applied
+	Retry(change StorageChange)
+}
+
+// reloadChanged applies changes made to the storage from outside the server.
+// Each one is checked the same way as when loading.  A change can depend on
+// another, like a reference to an element added in the same poll, so the
+// failed ones are tried again while any others succeed.  Those still failing
+// are reported and retried on the next poll.  The lock is only taken if
+// anything changed.
+func (r *Store) reloadChanged() {
+	r.saveMu.Lock()
+	defer r.saveMu.Unlock()
+
+	storage, err := r.getStorage()
+	if err != nil {
+		return
+	}
+	reloader, ok := storage.(Reloader)
+	if !ok {
+		return
+	}
+
+	var values, hashes []string
+	byFile := make(map[string]string)
+	r.RLock()
+	for name, rv := range r.values {
+		if rv.backingFile == "" {
+			continue
+		}
+		byFile[rv.backingFile] = name
+		if _, ok := rv.value.(*Hash); ok {
+			hashes = append(hashes, rv.backingFile)
+		} else {
+			values = append(values, rv.backingFile)
+		}
+	}
+	r.RUnlock()
+
+	changes, errs := reloader.Changes(values, hashes)
+	for _, err := range errs {
+		log.Errorf("Cannot reload config: %v", err)
+	}
+	if len(changes) == 0 {
+		return
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	for len(changes) > 0 {
+		var failed []StorageChange
+		var failedErrs []error
+		for _, change := range changes {
+			name := byFile[change.Name]
+			err := r.Transaction(func() error {
+				return r.reloadChange(name, change)
+			})
+			if err != nil {
+				failed = append(failed, change)
+				failedErrs = append(failedErrs, err)
+			} else {
+				log.Noticef("Reloaded %v[%v] from storage", name, change.Key)
+			}
+		}
+		if len(failed) == len(changes) {
+			for idx, change := range failed {
+				log.Errorf("Cannot reload %v[%v]: %v", byFile[change.Name], change.Key, failedErrs[idx])
+				reloader.Retry(change)
+			}
+			return
+		}
+		changes = failed
+	}
+}
+
+func (r *Store) reloadChange(name string, change StorageChange) error {
+	rv := r.values[name]
+	hash, isHash := rv.value.(*Hash)
+	if !isHash {
+		if change.Value == nil {
+			// A root cannot be removed, so its file is written again
+			log.Noticef("%v was deleted from storage, saving it again", name)
+			rv.value.SetSaveNeeded(true)
+			r.wakeSaver()
+			return nil
+		}
+		j, _, err := r.migrate(name, "", change.Value, nil)
+		if err != nil {
+			return err
+		}
+		if err := replaceJSON(rv.value, j); err != nil {
+			return err
+		}
+		rv.value.SetSaveNeeded(false)
+		return nil
+	}
+
+	if change.Value == nil {
+		if hash.Get(change.Key) != nil {
+			if err := hash.Delete(change.Key); err != nil {
+				return err
+			}
+		}
+		rv.setSaved(change.Key, false)
+		return nil
+	}
+
+	j, _, err := r.migrate(name, change.Key, change.Value, nil)
+	if err != nil {
+		return err
+	}
+	value := hash.Get(change.Key)
+	if value != nil {
+		err = replaceJSON(value, j)
+	} else {
+		value, err = hash.NewElement(change.Key, j)
+	}
+	if err != nil {
+		return err
+	}
+	value.SetSaveNeeded(false)
+	rv.setSaved(change.Key, true)
+	return nil
+}
+
+// replaceJSON sets value to j.  SetJSON only adds to hashes, but a reloaded
+// file replaces what was there, so elements of hashes in value that j leaves
+// out are deleted first.
+func replaceJSON(value Value, j json.Value) error {
+	if err := pruneHashes(value, j); err != nil {
+		return err
+	}
+	return value.SetJSON(j)
+}
+
+func pruneHashes(value Value, j json.Value) error {
+	jObject, ok := j.(json.Object)
+	if !ok {
+		return nil
+	}
+	switch value := value.(type) {
+	case *Hash:
+		for _, key := range value.Keys() {
+			jValue, ok := jObject[key]
+			if !ok {
+				if err := value.Delete(key); err != nil {
+					return err
+				}
+			} else if err := pruneHashes(value.Get(key), jValue); err != nil {
+				return err
+			}
+		}
+	case *Object:
+		for key, jValue := range jObject {
+			if child := value.Get(key); child != nil {
+				if err := pruneHashes(child, jValue); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
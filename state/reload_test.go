@@ -0,0 +1,134 @@
+package state
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/rollerderby/go/json"
+)
+
+func newTaggedObject() Value {
+	return &Object{Definition: ObjectDef{Name: "Tagged", Values: []ObjectValueDef{
+		{Name: "ID", Initializer: NewGUID},
+		{Name: "Tags", Initializer: NewHashOf(NewString)},
+	}}}
+}
+
+func TestReloadNestedHash(t *testing.T) {
+	s := NewStore(t.TempDir())
+	h := NewHashOf(newTaggedObject)().(*Hash)
+	s.Lock()
+	s.Add("Things", "things", h)
+	elem, _ := h.NewEmptyElement("")
+	tags := elem.(*Object).Get("Tags").(*Hash)
+	tags.NewElement("a", json.NewString("1"))
+	tags.NewElement("b", json.NewString("2"))
+	s.Unlock()
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	s.reloadChanged()
+
+	id := elem.(*Object).Get("ID").(*GUID).Value()
+	filename := path.Join(s.basePath, "config", "things", id+".json")
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j, err := json.Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(j.(json.Object)["Tags"].(json.Object), "b")
+	if err := ioutil.WriteFile(filename, []byte(j.JSON(false)), 0664); err != nil {
+		t.Fatal(err)
+	}
+	s.reloadChanged()
+
+	s.RLock()
+	defer s.RUnlock()
+	if keys := tags.Keys(); len(keys) != 1 || keys[0] != "a" {
+		t.Fatalf("Expected only tag a, got %v", keys)
+	}
+}
+
+func TestReloadDeletedRoot(t *testing.T) {
+	s := NewStore(t.TempDir())
+	obj := newSecretObject().(*Object)
+	s.Lock()
+	s.Add("Settings", "settings", obj)
+	obj.Get("Name").(*String).SetValue("a")
+	s.Unlock()
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	s.reloadChanged()
+
+	filename := path.Join(s.basePath, "config", "settings.json")
+	if err := os.Remove(filename); err != nil {
+		t.Fatal(err)
+	}
+	s.reloadChanged()
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filename); err != nil {
+		t.Fatalf("Deleted root was not saved again: %v", err)
+	}
+}
+
+func TestReloadReferences(t *testing.T) {
+	s, things := newSavedStore(t)
+	refs := NewHashOf(newRefObject)().(*Hash)
+	s.Lock()
+	s.Add("Refs", "refs", refs)
+	s.Unlock()
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	s.reloadChanged()
+
+	write := func(name, key string, j json.Object) {
+		dir := path.Join(s.configDir(), name)
+		if err := os.MkdirAll(dir, 0775); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path.Join(dir, key+".json"), []byte(j.JSON(false)), 0664); err != nil {
+			t.Fatal(err)
+		}
+	}
+	thing := func(key string) json.Object {
+		return json.Object{"ID": json.NewString(key), "Name": json.NewString(""), "Secret": json.NewString("")}
+	}
+	ref := func(key, thing string) json.Object {
+		return json.Object{"ID": json.NewString(key), "Thing": json.NewString(thing)}
+	}
+	loaded := func(h *Hash, key string) bool {
+		s.RLock()
+		defer s.RUnlock()
+		return h.Get(key) != nil
+	}
+
+	// Added in the same poll
+	write("things", "6ba7b810-9dad-11d1-80b4-00c04fd430c8", thing("6ba7b810-9dad-11d1-80b4-00c04fd430c8"))
+	write("refs", "6ba7b811-9dad-11d1-80b4-00c04fd430c8", ref("6ba7b811-9dad-11d1-80b4-00c04fd430c8", "6ba7b810-9dad-11d1-80b4-00c04fd430c8"))
+	s.reloadChanged()
+	if !loaded(things, "6ba7b810-9dad-11d1-80b4-00c04fd430c8") || !loaded(refs, "6ba7b811-9dad-11d1-80b4-00c04fd430c8") {
+		t.Fatal("Changes from one poll not all reloaded")
+	}
+
+	// A reference to something not there yet is retried on later polls
+	write("refs", "6ba7b813-9dad-11d1-80b4-00c04fd430c8", ref("6ba7b813-9dad-11d1-80b4-00c04fd430c8", "6ba7b812-9dad-11d1-80b4-00c04fd430c8"))
+	s.reloadChanged()
+	if loaded(refs, "6ba7b813-9dad-11d1-80b4-00c04fd430c8") {
+		t.Fatal("Reloaded a reference to a missing element")
+	}
+	s.reloadChanged()
+	write("things", "6ba7b812-9dad-11d1-80b4-00c04fd430c8", thing("6ba7b812-9dad-11d1-80b4-00c04fd430c8"))
+	s.reloadChanged()
+	if !loaded(refs, "6ba7b813-9dad-11d1-80b4-00c04fd430c8") {
+		t.Fatal("Failed change was not retried")
+	}
+}
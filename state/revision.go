@@ -0,0 +1,46 @@
+package state
+
+import (
+	"github.com/rollerderby/go/json"
+)
+
+// Writes can carry the store revision the writer's copy of a value is from
+// (the Revision of the State message it came in, or the store's Revision()
+// when it was read).  If the value or anything below it changed at or after
+// that revision the write is refused with an *ErrConflict, so two people
+// editing the same thing don't silently overwrite each other.
+
+// LatestRevision returns the newest revision of value and its descendants
+func LatestRevision(value Value) uint64 {
+	var rev uint64
+	Walk(value, func(v Value) bool {
+		if v.Revision() > rev {
+			rev = v.Revision()
+		}
+		return true
+	})
+	return rev
+}
+
+// CheckRevision returns an *ErrConflict if value changed since rev
+func CheckRevision(value Value, rev uint64) error {
+	if latest := LatestRevision(value); latest >= rev {
+		return &ErrConflict{Path: value.Path(), Revision: latest, Current: value.JSON(false), Value: value}
+	}
+	return nil
+}
+
+// SetPathIfUnchanged is SetPath, but fails with an *ErrConflict if any value
+// matching pattern changed since rev
+func (r *Store) SetPathIfUnchanged(pattern string, j json.Value, rev uint64) error {
+	values, err := r.Query(pattern)
+	if err != nil {
+		return err
+	}
+	for _, value := range values {
+		if err := CheckRevision(value, rev); err != nil {
+			return err
+		}
+	}
+	return r.SetPath(pattern, j)
+}
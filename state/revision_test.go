@@ -0,0 +1,56 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/rollerderby/go/json"
+)
+
+func TestSetPathIfUnchanged(t *testing.T) {
+	s, h := newTestStore(t)
+	s.Lock()
+	elem, _ := h.NewEmptyElement("")
+	s.Unlock()
+	s.Lock()
+	changed := s.Revision()
+	elem.(*Object).Get("Name").(*String).SetValue("a")
+	s.Unlock()
+	current := s.Revision()
+	if current != changed+1 {
+		t.Fatalf("Expected revision %v, got %v", changed+1, current)
+	}
+
+	namePath := elem.Path() + "[Name]"
+	tests := []struct {
+		rev      uint64
+		conflict bool
+	}{
+		{changed - 1, true},
+		{changed, true},
+		{current, false},
+		{current + 5, false},
+	}
+	for _, test := range tests {
+		s.Lock()
+		err := s.SetPathIfUnchanged(namePath, json.NewString("b"), test.rev)
+		s.Unlock()
+		conflict, isConflict := err.(*ErrConflict)
+		if isConflict != test.conflict || (!test.conflict && err != nil) {
+			t.Fatalf("Revision %v: unexpected error %v", test.rev, err)
+		}
+		if isConflict && (conflict.Revision != changed || conflict.Path != namePath || conflict.Current.JSON(false) != `"a"`) {
+			t.Fatalf("Revision %v: conflict is %+v", test.rev, conflict)
+		}
+	}
+
+	// Name was set to "b" at revision current, which conflicts for the
+	// element holding it too, and nothing is set
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.SetPathIfUnchanged(elem.Path(), json.Object{"Secret": json.NewString("c")}, current).(*ErrConflict); !ok {
+		t.Fatal("Expected a conflict for a change below the value")
+	}
+	if secret := elem.(*Object).Get("Secret").(*String).Value(); secret != "" {
+		t.Fatalf("Secret set to %q despite the conflict", secret)
+	}
+}
@@ -1,9 +1,8 @@
 package state
 
 import (
-	"io/ioutil"
+	"fmt"
 	"os"
-	"path"
 	"runtime"
 	"sync"
 	"time"
@@ -13,41 +12,150 @@ import (
 )
 
 var (
-	log  = *logger.New("state")
-	Root = &root{values: make(map[string]*rootValue), basePath: "."}
+	log = *logger.New("state")
+
+	// Root is the default store, used by the generated accessors
+	Root = NewStore(".")
 )
 
 type rootValue struct {
 	backingFile string
 	value       Value
+	savedKeys   map[string]bool // Hash keys with a config file
+	journalKeys map[string]bool // Hash keys as of the last journal entry
 }
 
-type root struct {
+// Store holds a state tree.  Values know their store by walking up to the top
+// of the tree they are in, so values from different stores never mix.
+type Store struct {
 	values   map[string]*rootValue
 	revision uint64
 	basePath string
 
-	mu       sync.Mutex // Lock the state so only one "changer" can update the state at a time
-	isLocked bool       // Not foolproof, but helps to detect someone updating the state without holding the lock
-	changed  bool       // Was the state changed between locks?
-	isReady  bool       // Is the system up and ready to go?
+	mu       sync.RWMutex // Lock the state so only one "changer" can update the state at a time, RLock to read it
+	isLocked bool         // Not foolproof, but helps to detect someone updating the state without holding the lock
+	changed  bool         // Was the state changed between locks?
+	isReady  bool         // Is the system up and ready to go?
+
+	changedValues []Value        // Values changed since Lock, in order of first change
+	changedSet    map[Value]bool // Quick lookup for changedValues
+
+	txs       []*transaction // Open transactions, innermost last
+	restoring bool           // Rolling back a transaction, changes are not tracked
+
+	user         string // Who is changing the state in this lock
+	remoteAddr   string // Where they are connected from
+	historySize  int
+	history      []*ChangeSet
+	redo         []*ChangeSet
+	cycle        []*historyEntry // Values changed in this lock, for history
+	cycleTouched map[Value]bool
+	replaying    bool // Undo or Redo in progress, changes are not recorded in history
+
+	journal     *os.File // Changes not yet saved to the config files, see journal.go
+	journalSize int64
+	skipJournal bool // The current lock only loaded what is already saved
+
+	audit        *os.File // See audit.go
+	auditSize    int64
+	auditCount   int
+	auditMaxSize int64
+
+	backupCount    int // See backup.go
+	backupInterval time.Duration
+	lastBackup     time.Time
+
+	computed  map[*Computed]bool // See computed.go
+	refs      map[*GUID]bool     // See reference.go
+	deferRefs bool               // References are checked once at the end, see RestoreBackup
+	indexed   map[*Hash]bool     // See index.go
+
+	migrations map[string][]Migration // See migrate.go
+
+	saveMu      sync.Mutex // Held while using storage, taken before mu.  See save.go
+	saveWake    chan struct{}
+	saveStop    chan struct{}
+	saveDone    chan struct{}
+	storage     Storage // See storage.go
+	openStorage OpenStorage
+
+	patchBase json.Object // JSON patches are built against, see patch.go
+
+	watchMu  sync.Mutex // Protects watchers, which can be added without holding the lock
+	watchers []*watcher
+	watchID  uint64
+}
+
+// NewStore returns an empty store that saves its configs and backups under
+// basePath
+func NewStore(basePath string) *Store {
+	return &Store{
+		values:         make(map[string]*rootValue),
+		basePath:       basePath,
+		historySize:    defaultHistorySize,
+		saveWake:       make(chan struct{}, 1),
+		auditCount:     10,
+		auditMaxSize:   10 * 1024 * 1024,
+		backupCount:    10,
+		backupInterval: time.Hour,
+	}
+}
+
+// storeOf returns the store value is in, or nil if it isn't in one
+func storeOf(value Value) *Store {
+	for value != nil {
+		if s, ok := value.(*Store); ok {
+			return s
+		}
+		value = value.Parent()
+	}
+	return nil
 }
 
-func (r *root) Lock() {
+func (r *Store) Lock() {
 	r.mu.Lock()
 	r.isLocked = true
 }
 
-func (r *root) Unlock() {
+// RLock locks the state for reading.  Any number of readers can hold it at
+// once, but not while it is locked for changes.  The value getters don't lock
+// themselves, so anything reading the state outside of Lock should hold
+// RLock or use View.
+func (r *Store) RLock() {
+	r.mu.RLock()
+}
+
+func (r *Store) RUnlock() {
+	r.mu.RUnlock()
+}
+
+// View calls f holding the read lock
+func (r *Store) View(f func()) {
+	r.RLock()
+	defer r.RUnlock()
+	f()
+}
+
+func (r *Store) Unlock() {
+	if len(r.txs) > 0 {
+		log.Critf("Unlocking with %v open transaction(s), committing them", len(r.txs))
+		for len(r.txs) > 0 {
+			r.Commit()
+		}
+	}
+	r.recompute()
+	r.commitHistory()
+	r.writeJournal()
 	if r.changed {
 		r.revision++
 		r.changed = false
 	}
 	r.isLocked = false
+	r.notifyWatchers()
 	r.mu.Unlock()
 }
 
-func (r *root) Get(key string) Value {
+func (r *Store) Get(key string) Value {
 	val := r.values[key]
 	if val == nil {
 		return nil
@@ -55,20 +163,20 @@ func (r *root) Get(key string) Value {
 	return val.value
 }
 
-func (r *root) IsReady() bool {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+func (r *Store) IsReady() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	return r.isReady
 }
 
-func (r *root) SetIsReady(isReady bool) {
+func (r *Store) SetIsReady(isReady bool) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	r.isReady = isReady
 }
 
-func (r *root) changedValue(value Value) {
-	if value.Parent() == nil {
+func (r *Store) changedValue(value Value) {
+	if value.Parent() == nil || r.restoring {
 		return
 	}
 
@@ -86,30 +194,36 @@ func (r *root) changedValue(value Value) {
 
 	value.SetRevision(r.revision)
 	r.changed = true
+	r.trackChange(value)
+	r.updateIndexes(value)
 
 	for value.Parent() != r {
 		value.SetSaveNeeded(true)
 		value = value.Parent()
 	}
+	value.SetSaveNeeded(true) // A root that isn't a hash is saved whole
+	if rv := r.values[value.Path()]; rv != nil && rv.backingFile != "" {
+		r.wakeSaver()
+	}
 }
 
-func (r *root) WriteGroups() []string         { return nil }
-func (r *root) AddWriteGroup(group ...string) {}
-func (r *root) ReadGroups() []string          { return nil }
-func (r *root) AddReadGroup(group ...string)  {}
-func (r *root) SaveNeeded() bool              { return false }
-func (r *root) SetSaveNeeded(skip bool)       {}
-func (r *root) SkipSave() bool                { return false }
-func (r *root) SetSkipSave(skip bool)         {}
-func (r *root) Revision() uint64              { return r.revision }
-func (r *root) SetRevision(rev uint64)        {}
+func (r *Store) WriteGroups() []string         { return nil }
+func (r *Store) AddWriteGroup(group ...string) {}
+func (r *Store) ReadGroups() []string          { return nil }
+func (r *Store) AddReadGroup(group ...string)  {}
+func (r *Store) SaveNeeded() bool              { return false }
+func (r *Store) SetSaveNeeded(skip bool)       {}
+func (r *Store) SkipSave() bool                { return false }
+func (r *Store) SetSkipSave(skip bool)         {}
+func (r *Store) Revision() uint64              { return r.revision }
+func (r *Store) SetRevision(rev uint64)        {}
 
-func (r *root) Parent() Value { return nil }
-func (r *root) Path() string  { return "" }
+func (r *Store) Parent() Value { return nil }
+func (r *Store) Path() string  { return "" }
 
-func (r *root) SetParentAndPath(parent Value, path string) {}
+func (r *Store) SetParentAndPath(parent Value, path string) {}
 
-func (r *root) JSON(skipSave bool) json.Value {
+func (r *Store) JSON(skipSave bool) json.Value {
 	j := make(json.Object)
 	for key, value := range r.values {
 		j[key] = value.value.JSON(skipSave)
@@ -118,15 +232,15 @@ func (r *root) JSON(skipSave bool) json.Value {
 	return j
 }
 
-func (r *root) SetJSON(j json.Value) error {
+func (r *Store) SetJSON(j json.Value) error {
 	return errNotImplemented
 }
 
-func (r *root) String() string {
+func (r *Store) String() string {
 	return "ROOT"
 }
 
-func (r *root) Add(name, backingFile string, value Value) error {
+func (r *Store) Add(name, backingFile string, value Value) error {
 	if _, ok := r.values[name]; ok {
 		return errExistingKey(name)
 	}
@@ -141,19 +255,43 @@ func (r *root) Add(name, backingFile string, value Value) error {
 	return nil
 }
 
-func (r *root) LoadSavedConfigs() error {
+func (rv *rootValue) setSaved(key string, saved bool) {
+	if saved {
+		if rv.savedKeys == nil {
+			rv.savedKeys = make(map[string]bool)
+		}
+		rv.savedKeys[key] = true
+	} else {
+		delete(rv.savedKeys, key)
+	}
+}
+
+func (r *Store) LoadSavedConfigs() error {
 	// Take lock so we can load config files in to the active state
+	r.saveMu.Lock()
+	defer r.saveMu.Unlock()
 	r.Lock()
 	defer r.Unlock()
 
 	var errors []error
 
-	loadJSON := func(filename string) (json.Value, error) {
-		data, err := ioutil.ReadFile(filename)
-		if err != nil {
-			return nil, err
+	// Keep a copy of the config as it was before any file is migrated
+	backedUp := false
+	beforeMigrate := func() error {
+		if backedUp {
+			return nil
+		}
+		if _, err := r.backup(); err != nil {
+			return fmt.Errorf("Cannot backup config before migrating: %v", err)
 		}
-		return json.Decode(data)
+		backedUp = true
+		return nil
+	}
+
+	storage, err := r.getStorage()
+	if err != nil {
+		log.Errorf("Cannot open storage: %v", err)
+		return err
 	}
 
 	for valueName, value := range r.values {
@@ -161,53 +299,58 @@ func (r *root) LoadSavedConfigs() error {
 			continue
 		}
 		if hash, ok := value.value.(*Hash); ok {
-			dir := path.Join(r.basePath, "config", value.backingFile)
-
-			files, err := ioutil.ReadDir(dir)
-			if err != nil {
-				errors = append(errors, err)
-				continue
-			}
-
-			for _, fi := range files {
-				key := fi.Name()
-				key = key[:len(key)-len(path.Ext(fi.Name()))]
-
-				json, err := loadJSON(path.Join(dir, fi.Name()))
-				if err != nil {
-					errors = append(errors, err)
-					continue
-				}
+			jValues, errs := storage.LoadHash(value.backingFile)
+			errors = append(errors, errs...)
 
+			for key, json := range jValues {
 				if val := hash.Get(key); val == nil {
-					log.Infof("Loading %q into hash %q as key %q", fi.Name(), valueName, key)
+					log.Infof("Loading key %q into hash %q", key, valueName)
+					json, migrated, err := r.migrate(valueName, key, json, beforeMigrate)
+					if err != nil {
+						errors = append(errors, err)
+						continue
+					}
 					if newValue, err := hash.NewElement(key, json); err != nil {
 						errors = append(errors, err)
+						continue
 					} else {
-						newValue.SetSaveNeeded(false)
+						newValue.SetSaveNeeded(migrated)
 					}
 				}
+				value.setSaved(key, true)
 			}
 		} else {
-			filename := path.Join(r.basePath, "config", value.backingFile+".json")
-			log.Infof("Looking for config %q for %q", filename, valueName)
+			log.Infof("Looking for config %q for %q", value.backingFile, valueName)
 
-			json, err := loadJSON(filename)
+			json, err := storage.Load(value.backingFile)
 			if err != nil {
 				errors = append(errors, err)
 				continue
 			}
 
-			log.Infof("Loading %q into %q", filename, valueName)
+			log.Infof("Loading %q into %q", value.backingFile, valueName)
+			json, migrated, err := r.migrate(valueName, "", json, beforeMigrate)
+			if err != nil {
+				errors = append(errors, err)
+				continue
+			}
 			if err := value.value.SetJSON(json); err != nil {
 				errors = append(errors, err)
 				continue
 			}
 
-			value.value.SetSaveNeeded(false)
+			value.value.SetSaveNeeded(migrated)
 		}
 	}
 
+	errors = append(errors, r.replayJournal()...)
+
+	// Changes from now on are journaled, even before SaveLoop starts
+	if err := r.openJournal(); err != nil {
+		log.Errorf("Cannot open journal, changes not yet saved can be lost in a crash: %v", err)
+	}
+	r.skipJournal = true
+
 	if len(errors) > 0 {
 		log.Error("Errors while loading configs")
 		for _, err := range errors {
@@ -216,48 +359,3 @@ func (r *root) LoadSavedConfigs() error {
 	}
 	return nil
 }
-
-// Runs in a goroutine
-func (r *root) SaveLoop() {
-	save := func(filename string, json json.Value) {
-		dir := path.Dir(filename)
-		if err := os.MkdirAll(dir, 0775); err != nil {
-			log.Errorf("Cannot create director %q: %v", dir, err)
-		} else if err := ioutil.WriteFile(filename, []byte(json.JSON(true)), 0664); err != nil {
-			log.Errorf("Cannot save config file %q: %v", filename, err)
-		} else {
-			log.Infof("Saved config file %q", filename)
-		}
-	}
-	saveAllNeeded := func() {
-		// Take lock so no data is changed while we save
-		r.Lock()
-		defer r.Unlock()
-		for _, value := range r.values {
-			if value.backingFile == "" {
-				continue
-			}
-			if hash, ok := value.value.(*Hash); ok {
-				for _, key := range hash.Keys() {
-					stateValue := hash.Get(key)
-					if stateValue.SaveNeeded() {
-						json := stateValue.JSON(true)
-						save(path.Join(r.basePath, "config", value.backingFile, key+".json"), json)
-						stateValue.SetSaveNeeded(false)
-					}
-				}
-			} else {
-				if value.value.SaveNeeded() {
-					json := value.value.JSON(true)
-					save(path.Join(r.basePath, "config", value.backingFile+".json"), json)
-					value.value.SetSaveNeeded(false)
-				}
-			}
-		}
-	}
-	r.SetIsReady(true)
-	for {
-		saveAllNeeded()
-		time.Sleep(1 * time.Second)
-	}
-}
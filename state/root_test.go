@@ -0,0 +1,96 @@
+package state
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+func TestStoreIsolation(t *testing.T) {
+	a, aThings := newSavedStore(t)
+	b, bThings := newSavedStore(t)
+	var aWatched, bWatched int
+	a.Watch("", func([]Value) { aWatched++ })
+	b.Watch("", func([]Value) { bWatched++ })
+	aRev, bRev := a.Revision(), b.Revision()
+
+	a.Lock()
+	elem, _ := aThings.NewEmptyElement("")
+	elem.(*Object).Get("Name").(*String).SetValue("a")
+	a.Unlock()
+	key := elem.(*Object).Get("ID").(*GUID).Value()
+
+	if storeOf(elem.(*Object).Get("Name")) != a {
+		t.Fatal("Value does not find its store")
+	}
+	if aWatched != 1 || bWatched != 0 {
+		t.Fatalf("Watchers called %v and %v times", aWatched, bWatched)
+	}
+	if b.Revision() != bRev || len(b.History()) != 0 || len(bThings.Keys()) != 0 {
+		t.Fatalf("Changing one store changed the other: revision %v, %v history", b.Revision(), len(b.History()))
+	}
+	if a.Revision() == aRev || len(a.History()) != 1 {
+		t.Fatalf("Store not changed: revision %v, %v history", a.Revision(), len(a.History()))
+	}
+
+	// Each saves under its own base path
+	if err := a.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path.Join(a.configDir(), "things", key+".json")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path.Join(b.configDir(), "things", key+".json")); !os.IsNotExist(err) {
+		t.Fatalf("Saved in the other store: %v", err)
+	}
+
+	// Undo only reverts the store's own changes
+	b.Lock()
+	if _, err := b.Undo(); err == nil {
+		t.Fatal("Undo in the other store succeeded")
+	}
+	b.Unlock()
+	a.Lock()
+	if _, err := a.Undo(); err != nil {
+		t.Fatal(err)
+	}
+	a.Unlock()
+	if len(aThings.Keys()) != 0 {
+		t.Fatal("Undo did not remove the element")
+	}
+}
+
+func TestValueWithoutStore(t *testing.T) {
+	h := NewHashOf(newSecretObject)().(*Hash)
+	elem, err := h.NewEmptyElement("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := elem.(*Object).Get("Name").(*String)
+	if storeOf(name) != nil {
+		t.Fatal("Value not added to a store has one")
+	}
+	if err := name.SetValue("loose"); err != nil {
+		t.Fatal(err)
+	}
+	if name.Value() != "loose" {
+		t.Fatalf("Name is %q", name.Value())
+	}
+
+	// Added, it belongs to the store holding it
+	s, _ := newTestStore(t)
+	s.Lock()
+	defer s.Unlock()
+	if err := s.Add("Loose", "", h); err != nil {
+		t.Fatal(err)
+	}
+	if storeOf(name) != s {
+		t.Fatal("Value does not find the store it was added to")
+	}
+	if Root.Get("Loose") != nil {
+		t.Fatal("A new store shares its roots with Root")
+	}
+}
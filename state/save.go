@@ -0,0 +1,244 @@
+package state
+
+import (
+	"time"
+)
+
+// Changes to roots with a backing file wake SaveLoop, which waits saveDelay
+// so a burst of changes is saved together, then saves them.  Only taking
+// the snapshot of what to save needs the lock, the storage is written
+// while other goroutines keep changing the state.  Anything changed but
+// not yet saved is in the journal, and Close saves it all before exiting.
+//
+// saveMu is held while the storage is used, so there is only one save at a
+// time.  It is always taken before the lock.
+
+var (
+	saveDelay      = 500 * time.Millisecond
+	reloadInterval = time.Second // How often SaveLoop checks storage for outside changes
+)
+
+// pendingSave is everything to save, as of when it was snapshotted.
+// changes[i] saves values[i] of roots[i].  journalSize is how much of the
+// journal it covers.
+type pendingSave struct {
+	storage     Storage
+	journalSize int64
+	changes     []StorageChange
+	values      []Value
+	roots       []*rootValue
+}
+
+// wakeSaver tells SaveLoop there is something to save
+func (r *Store) wakeSaver() {
+	select {
+	case r.saveWake <- struct{}{}:
+	default:
+	}
+}
+
+// snapshotSave collects every value that needs saving and marks it saved.
+// saveMu and the lock must be held.
+func (r *Store) snapshotSave() (*pendingSave, error) {
+	storage, err := r.getStorage()
+	if err != nil {
+		return nil, err
+	}
+
+	p := &pendingSave{storage: storage, journalSize: r.journalSize}
+	for _, value := range r.values {
+		if value.backingFile == "" {
+			continue
+		}
+		if hash, ok := value.value.(*Hash); ok {
+			for _, key := range hash.Keys() {
+				stateValue := hash.Get(key)
+				if stateValue.SaveNeeded() {
+					json := r.stamp(value.value.Path(), stateValue.JSON(true))
+					p.add(StorageChange{Name: value.backingFile, Key: key, Value: json}, stateValue, value)
+				}
+			}
+			for key := range value.savedKeys {
+				if hash.Get(key) == nil {
+					p.add(StorageChange{Name: value.backingFile, Key: key}, nil, value)
+				}
+			}
+		} else {
+			if value.value.SaveNeeded() {
+				json := r.stamp(value.value.Path(), value.value.JSON(true))
+				p.add(StorageChange{Name: value.backingFile, Value: json}, value.value, value)
+			}
+		}
+	}
+
+	// Cleared now so changes made while writing are saved next time
+	for _, value := range p.values {
+		if value != nil {
+			value.SetSaveNeeded(false)
+		}
+	}
+	return p, nil
+}
+
+func (p *pendingSave) add(change StorageChange, value Value, root *rootValue) {
+	p.changes = append(p.changes, change)
+	p.values = append(p.values, value)
+	p.roots = append(p.roots, root)
+}
+
+// write saves the snapshot.  Only saveMu needs to be held.
+func (p *pendingSave) write() error {
+	if len(p.changes) == 0 {
+		return nil
+	}
+	return p.storage.Save(p.changes)
+}
+
+// finishSave records the result of writing p.  saveMu and the lock must be
+// held.
+func (r *Store) finishSave(p *pendingSave, err error) error {
+	if err != nil {
+		// Anything that was saved is saved again next time
+		for _, value := range p.values {
+			if value != nil {
+				value.SetSaveNeeded(true)
+			}
+		}
+		return err
+	}
+
+	for idx, change := range p.changes {
+		if change.Key != "" {
+			p.roots[idx].setSaved(change.Key, change.Value != nil)
+		}
+	}
+
+	// Entries journaled while p was written are not saved yet
+	r.truncateJournal(p.journalSize)
+	return nil
+}
+
+// saveAllNeeded writes every value that changed to storage while holding
+// the lock, so no data is changed while we save.  saveMu and the lock must
+// be held.
+func (r *Store) saveAllNeeded() bool {
+	p, err := r.snapshotSave()
+	if err == nil {
+		err = r.finishSave(p, p.write())
+	}
+	if err != nil {
+		log.Errorf("Cannot save config: %v", err)
+		return false
+	}
+	return true
+}
+
+// save writes every value that changed to storage.  The lock is only held
+// to snapshot the values and to record the result, not while writing.
+func (r *Store) save() error {
+	r.saveMu.Lock()
+	defer r.saveMu.Unlock()
+
+	r.Lock()
+	p, err := r.snapshotSave()
+	r.Unlock()
+	if err != nil {
+		log.Errorf("Cannot save config: %v", err)
+		return err
+	}
+
+	err = p.write()
+
+	r.Lock()
+	defer r.Unlock()
+	if err = r.finishSave(p, err); err != nil {
+		log.Errorf("Cannot save config: %v", err)
+		return err
+	}
+	if len(p.changes) > 0 {
+		r.backupIfDue()
+	}
+	return nil
+}
+
+// Flush saves every change made so far.  It must not be called with the
+// lock held.
+func (r *Store) Flush() error {
+	return r.save()
+}
+
+// SaveLoop saves changes shortly after they are made, and merges changes
+// made to the storage from outside the server.  It runs until Close.
+func (r *Store) SaveLoop() {
+	stop, done := make(chan struct{}), make(chan struct{})
+	defer close(done)
+
+	r.saveMu.Lock()
+	r.Lock()
+	if r.journal == nil {
+		if err := r.openJournal(); err != nil {
+			log.Errorf("Cannot open journal, changes not yet saved can be lost in a crash: %v", err)
+		}
+	}
+	r.backupIfDue()
+	r.saveStop, r.saveDone = stop, done
+	r.Unlock()
+	r.saveMu.Unlock()
+
+	r.SetIsReady(true)
+
+	poll := time.NewTicker(reloadInterval)
+	defer poll.Stop()
+	failed := false
+	for {
+		select {
+		case <-stop:
+			return
+		case <-poll.C:
+			r.reloadChanged()
+			if !failed {
+				continue
+			}
+		case <-r.saveWake:
+			select {
+			case <-stop:
+				return
+			case <-time.After(saveDelay):
+			}
+		}
+		failed = r.save() != nil
+	}
+}
+
+// Close stops SaveLoop and saves every change, then closes the storage,
+// journal and audit log.  If saving fails the changes are left in the
+// journal, to be replayed by LoadSavedConfigs.  It must not be called with
+// the lock held.
+func (r *Store) Close() error {
+	r.saveMu.Lock()
+	stop, done := r.saveStop, r.saveDone
+	r.saveStop, r.saveDone = nil, nil
+	r.saveMu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+
+	err := r.save()
+
+	r.saveMu.Lock()
+	defer r.saveMu.Unlock()
+	r.Lock()
+	defer r.Unlock()
+
+	r.closeStorage()
+	if r.journal != nil {
+		if err := r.journal.Close(); err != nil {
+			log.Errorf("Cannot close journal: %v", err)
+		}
+		r.journal = nil
+	}
+	r.closeAudit()
+	r.isReady = false
+	return err
+}
@@ -0,0 +1,79 @@
+package state
+
+import (
+	"path"
+
+	"github.com/rollerderby/go/json"
+)
+
+// Storage keeps the saved copy of every root added with a backing file.  The
+// name passed to it is the backing file given to Root.Add.
+type Storage interface {
+	// Load returns the saved value of a root that isn't a hash.  The error
+	// satisfies os.IsNotExist if nothing is saved.
+	Load(name string) (json.Value, error)
+	// LoadHash returns every saved element of a hash, by key
+	LoadHash(name string) (map[string]json.Value, []error)
+	// Save applies all the changes, or as many as it can if it returns an
+	// error
+	Save(changes []StorageChange) error
+	Close() error
+}
+
+// StorageChange is a root, or an element of a hash, to save
+type StorageChange struct {
+	Name  string
+	Key   string     // Empty unless Name is a hash
+	Value json.Value // nil to remove Key from the hash
+}
+
+// OpenStorage opens the storage kept in a config folder, like
+// NewDirStorage or NewBoltStorage.  A storage opened read only never changes
+// the folder, and Save fails.
+type OpenStorage func(dir string, readOnly bool) (Storage, error)
+
+// SetStorage picks how configs are stored.  It must be called before
+// LoadSavedConfigs, the default is NewDirStorage.
+func (r *Store) SetStorage(open OpenStorage) {
+	r.saveMu.Lock()
+	defer r.saveMu.Unlock()
+	r.Lock()
+	defer r.Unlock()
+
+	r.closeStorage()
+	r.openStorage = open
+}
+
+func (r *Store) storageOpener() OpenStorage {
+	if r.openStorage == nil {
+		return NewDirStorage
+	}
+	return r.openStorage
+}
+
+func (r *Store) configDir() string {
+	return path.Join(r.basePath, "config")
+}
+
+// getStorage opens the storage on first use.  saveMu must be held.
+func (r *Store) getStorage() (Storage, error) {
+	if r.storage != nil {
+		return r.storage, nil
+	}
+	storage, err := r.storageOpener()(r.configDir(), false)
+	if err != nil {
+		return nil, err
+	}
+	r.storage = storage
+	return storage, nil
+}
+
+func (r *Store) closeStorage() {
+	if r.storage == nil {
+		return
+	}
+	if err := r.storage.Close(); err != nil {
+		log.Errorf("Cannot close storage: %v", err)
+	}
+	r.storage = nil
+}
@@ -2,11 +2,15 @@ package state
 
 import (
 	"fmt"
+	"regexp"
+	"unicode/utf8"
 
 	"github.com/rollerderby/go/json"
 )
 
 type String struct {
+	def         *StringDef
+	pattern     *regexp.Regexp
 	value       string
 	parent      Value
 	path        string
@@ -17,27 +21,71 @@ type String struct {
 	readGroups  []string
 }
 
+// StringDef limits the values a String accepts and sets its initial value.
+// Pattern must match the whole string.
+type StringDef struct {
+	Pattern   string
+	MaxLength int
+	Required  bool
+	Default   string
+}
+
 func NewString() Value { return &String{} }
 
+func NewStringOf(def StringDef) func() Value {
+	var pattern *regexp.Regexp
+	if def.Pattern != "" {
+		pattern = regexp.MustCompile("^(?:" + def.Pattern + ")$")
+	}
+	return func() Value {
+		return &String{def: &def, pattern: pattern, value: def.Default}
+	}
+}
+
 func (obj *String) Value() string {
 	return obj.value
 }
 
 func (obj *String) SetValue(val string) error {
+	if err := obj.check(val); err != nil {
+		return err
+	}
 	if obj.value == val {
 		return nil
 	}
+	if err := checkUnique(obj, val); err != nil {
+		return err
+	}
+	changingValue(obj)
 	obj.value = val
-	Root.changedValue(obj)
+	changedValue(obj)
+	return nil
+}
+
+// check returns an ErrConstraint if val breaks the StringDef
+func (obj *String) check(val string) error {
+	if obj.def == nil {
+		return nil
+	}
+	if obj.def.Required && val == "" {
+		return errConstraint(obj, val, "required")
+	}
+	if obj.def.MaxLength > 0 && utf8.RuneCountInString(val) > obj.def.MaxLength {
+		return errConstraint(obj, val, fmt.Sprintf("longer than %v", obj.def.MaxLength))
+	}
+	if obj.pattern != nil && val != "" && !obj.pattern.MatchString(val) {
+		return errConstraint(obj, val, fmt.Sprintf("does not match %q", obj.def.Pattern))
+	}
 	return nil
 }
 
+func (obj *String) validate() error { return obj.check(obj.value) }
+
 func (obj *String) WriteGroups() []string {
 	return obj.writeGroups
 }
 func (obj *String) AddWriteGroup(group ...string) {
 	obj.writeGroups = mergeGroups(obj.writeGroups, group)
-	obj.readGroups = mergeGroups(obj.readGroups, group)
 }
 func (obj *String) ReadGroups() []string {
 	return obj.readGroups
@@ -58,7 +106,12 @@ func (obj *String) String() string         { return fmt.Sprintf("%q", obj.value)
 func (obj *String) SetParentAndPath(parent Value, path string) {
 	obj.parent = parent
 	obj.path = path
-	Root.changedValue(obj)
+	changedValue(obj)
+}
+
+func (obj *String) snapshot() func() {
+	value, revision := obj.value, obj.revision
+	return func() { obj.value, obj.revision = value, revision }
 }
 
 func (obj *String) JSON(skipSave bool) json.Value {
@@ -67,23 +120,19 @@ func (obj *String) JSON(skipSave bool) json.Value {
 
 func (obj *String) SetJSON(j json.Value) error {
 	if j == json.True {
-		obj.SetValue("true")
-		return nil
+		return obj.SetValue("true")
 	} else if j == json.False {
-		obj.SetValue("false")
-		return nil
+		return obj.SetValue("false")
 	} else if j == json.Null {
-		obj.SetValue("null")
-		return nil
+		return obj.SetValue("null")
 	}
 
 	switch j := j.(type) {
 	case *json.String:
-		obj.SetValue(j.Get())
+		return obj.SetValue(j.Get())
 	case *json.Number:
-		obj.SetValue(j.String())
+		return obj.SetValue(j.String())
 	default:
 		return errInvalidJSONType(j, json.StringValue, conversionTypes, json.NumberValue, json.TrueValue, json.FalseValue, json.NullValue)
 	}
-	return nil
 }
@@ -0,0 +1,58 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/rollerderby/go/json"
+)
+
+func newRequiredObject() Value {
+	return &Object{Definition: ObjectDef{Name: "Required", Values: []ObjectValueDef{
+		{Name: "ID", Initializer: NewGUID},
+		{Name: "Name", Initializer: NewStringOf(StringDef{Required: true, MaxLength: 5})},
+		{Name: "Count", Initializer: NewNumberOf(NumberDef{Min: Int64(1), Default: 1})},
+	}}}
+}
+
+func TestStringConstraints(t *testing.T) {
+	str := NewStringOf(StringDef{Required: true})().(*String)
+	if err := str.SetValue(""); err == nil {
+		t.Fatal("Required string set to its empty initial value")
+	}
+
+	str = NewStringOf(StringDef{Pattern: "[a-z]+", MaxLength: 3})().(*String)
+	for val, ok := range map[string]bool{"": true, "abc": true, "abcd": false, "ab1": false} {
+		if err := str.SetValue(val); (err == nil) != ok {
+			t.Fatalf("SetValue(%q) returned %v", val, err)
+		}
+	}
+}
+
+func TestNewElementConstraints(t *testing.T) {
+	h := NewHashOf(newRequiredObject)().(*Hash)
+	tests := []struct {
+		j  json.Object
+		ok bool
+	}{
+		{json.Object{"ID": json.NewString(""), "Name": json.NewString("a"), "Count": json.NewNumber(1)}, true},
+		{json.Object{"ID": json.NewString(""), "Name": json.NewString(""), "Count": json.NewNumber(1)}, false},
+		{json.Object{"ID": json.NewString(""), "Name": json.NewString("abcdef"), "Count": json.NewNumber(1)}, false},
+		{json.Object{"ID": json.NewString(""), "Name": json.NewString("a"), "Count": json.NewNumber(0)}, false},
+	}
+	for _, test := range tests {
+		if _, err := h.NewElement("", test.j); (err == nil) != test.ok {
+			t.Fatalf("NewElement(%v) returned %v", test.j.JSON(false), err)
+		}
+	}
+	if n := len(h.Keys()); n != 1 {
+		t.Fatalf("Expected 1 element, got %v", n)
+	}
+
+	a := NewArrayOf(newRequiredObject)().(*Array)
+	if _, err := a.NewElement(json.Object{"ID": json.NewString(""), "Name": json.NewString(""), "Count": json.NewNumber(1)}); err == nil {
+		t.Fatal("Array element added with an empty Required field")
+	}
+	if _, err := a.NewEmptyElement(); err != nil {
+		t.Fatalf("Empty elements are filled in later: %v", err)
+	}
+}
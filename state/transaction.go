@@ -0,0 +1,138 @@
+package state
+
+// snapshotter is implemented by every value type.  snapshot returns a
+// function that puts the value back the way it was when snapshot was called.
+type snapshotter interface {
+	snapshot() func()
+}
+
+type txEntry struct {
+	value   Value
+	restore func()
+}
+
+type transaction struct {
+	touched    map[Value]bool
+	entries    []txEntry
+	changed    bool
+	numChanged int
+	numCycle   int // Length of the store's history cycle at Begin
+}
+
+// Begin starts a transaction.  The lock must be held, and the transaction
+// must be ended with Commit or Rollback before calling Unlock.  Transactions
+// can be nested.
+func (r *Store) Begin() {
+	r.txs = append(r.txs, &transaction{
+		touched:    make(map[Value]bool),
+		changed:    r.changed,
+		numChanged: len(r.changedValues),
+		numCycle:   len(r.cycle),
+	})
+}
+
+// Commit keeps the changes made since the matching Begin
+func (r *Store) Commit() {
+	tx := r.popTx()
+	if tx == nil || len(r.txs) == 0 {
+		return
+	}
+
+	// Hand the snapshots to the enclosing transaction so it can still roll back
+	outer := r.txs[len(r.txs)-1]
+	for _, entry := range tx.entries {
+		if !outer.touched[entry.value] {
+			outer.touched[entry.value] = true
+			outer.entries = append(outer.entries, entry)
+		}
+	}
+}
+
+// Rollback restores every value changed since the matching Begin
+func (r *Store) Rollback() {
+	tx := r.popTx()
+	if tx == nil {
+		return
+	}
+
+	r.restoring = true
+	for i := len(tx.entries) - 1; i >= 0; i-- {
+		tx.entries[i].restore()
+	}
+	r.restoring = false
+	r.rebuildIndexes()
+
+	r.trimHistory(tx.numCycle)
+	r.changed = tx.changed
+	r.changedValues = r.changedValues[:tx.numChanged]
+	r.changedSet = make(map[Value]bool)
+	for _, value := range r.changedValues {
+		r.changedSet[value] = true
+	}
+}
+
+// Transaction runs f inside Begin and Commit, or Rollback if f returns an
+// error.  The lock must be held.
+func (r *Store) Transaction(f func() error) error {
+	r.Begin()
+	if err := f(); err != nil {
+		r.Rollback()
+		return err
+	}
+	r.Commit()
+	return nil
+}
+
+func (r *Store) popTx() *transaction {
+	if len(r.txs) == 0 {
+		log.Critf("Transaction ended without calling Begin")
+		return nil
+	}
+	tx := r.txs[len(r.txs)-1]
+	r.txs = r.txs[:len(r.txs)-1]
+	return tx
+}
+
+// atomic runs f as a transaction if value is in a store
+func atomic(value Value, f func() error) error {
+	if s := storeOf(value); s != nil {
+		return s.Transaction(f)
+	}
+	return f()
+}
+
+// changingValue and changedValue pass a change on to the store value is in
+func changingValue(value Value) {
+	if s := storeOf(value); s != nil {
+		s.changingValue(value)
+	}
+}
+
+func changedValue(value Value) {
+	if s := storeOf(value); s != nil {
+		s.changedValue(value)
+	}
+}
+
+// changingValue must be called before value is changed so the change can be
+// rolled back or undone
+func (r *Store) changingValue(value Value) {
+	if r.restoring || value.Parent() == nil {
+		return
+	}
+	s, ok := value.(snapshotter)
+	if !ok {
+		return
+	}
+
+	r.recordHistory(value, s)
+
+	if len(r.txs) == 0 {
+		return
+	}
+	tx := r.txs[len(r.txs)-1]
+	if !tx.touched[value] {
+		tx.touched[value] = true
+		tx.entries = append(tx.entries, txEntry{value: value, restore: s.snapshot()})
+	}
+}
@@ -0,0 +1,78 @@
+package state
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/rollerderby/go/json"
+)
+
+func TestTransactionRollback(t *testing.T) {
+	s, h := newTestStore(t)
+	s.Lock()
+	elem, _ := h.NewEmptyElement("")
+	obj := elem.(*Object)
+	obj.Get("Name").(*String).SetValue("orig")
+	s.Unlock()
+
+	before := h.JSON(false).JSON(false)
+	rev := s.Revision()
+
+	// Name is applied before Secret fails
+	s.Lock()
+	err := obj.SetJSON(json.Object{"ID": obj.Get("ID").JSON(false), "Name": json.NewString("new"), "Secret": json.Array{}})
+	if err == nil {
+		t.Fatal("SetJSON accepted a bad value")
+	}
+	if err := s.Transaction(func() error {
+		if _, err := h.NewEmptyElement(""); err != nil {
+			return err
+		}
+		return errors.New("fail")
+	}); err == nil {
+		t.Fatal("Transaction did not return the error")
+	}
+	s.Unlock()
+
+	if after := h.JSON(false).JSON(false); after != before {
+		t.Fatalf("Rolled back changes kept: %v", after)
+	}
+	if s.Revision() != rev {
+		t.Fatalf("Revision bumped by rolled back changes: %v to %v", rev, s.Revision())
+	}
+}
+
+func TestTransactionNested(t *testing.T) {
+	s, h := newTestStore(t)
+	s.Lock()
+	elem, _ := h.NewEmptyElement("")
+	s.Unlock()
+	name := elem.(*Object).Get("Name").(*String)
+	secret := elem.(*Object).Get("Secret").(*String)
+
+	s.Lock()
+	s.Begin()
+	name.SetValue("outer")
+	s.Transaction(func() error {
+		secret.SetValue("inner")
+		return errors.New("fail")
+	})
+	s.Commit()
+	s.Unlock()
+	if name.Value() != "outer" || secret.Value() != "" {
+		t.Fatalf("Expected only the outer change, got %q and %q", name.Value(), secret.Value())
+	}
+
+	// Committed inner changes are still rolled back with the outer one
+	s.Lock()
+	s.Begin()
+	s.Transaction(func() error {
+		secret.SetValue("inner")
+		return nil
+	})
+	s.Rollback()
+	s.Unlock()
+	if secret.Value() != "" {
+		t.Fatalf("Inner change survived the outer rollback: %q", secret.Value())
+	}
+}
@@ -21,12 +21,34 @@ type Value interface {
 
 	String() string
 
+	// Write groups limit who may change a value and read groups who may see
+	// it, see groups.go.  Adding write groups does not add read groups: a
+	// value with only write groups can be read by anyone.
 	WriteGroups() []string
 	AddWriteGroup(group ...string)
 	ReadGroups() []string
 	AddReadGroup(group ...string)
 }
 
+// validator is implemented by values with constraints, like StringDef
+type validator interface {
+	validate() error
+}
+
+// validate checks the constraints of value and everything in it.  Setters
+// check their own value, this catches the ones never set, like fields left
+// out of the JSON for a new element.
+func validate(value Value) error {
+	var err error
+	Walk(value, func(v Value) bool {
+		if vv, ok := v.(validator); ok && err == nil {
+			err = vv.validate()
+		}
+		return err == nil
+	})
+	return err
+}
+
 func mergeGroups(a, b []string) []string {
 	var ret []string
 
@@ -0,0 +1,55 @@
+package state
+
+// Children returns the direct children of a container value (Object, Hash,
+// Array or the root) or nil for anything else.
+func Children(value Value) []Value {
+	switch value := value.(type) {
+	case *Store:
+		var ret []Value
+		for _, rv := range value.values {
+			ret = append(ret, rv.value)
+		}
+		return ret
+	case *Object:
+		return value.Values()
+	case *Hash:
+		return value.Values()
+	case *Array:
+		return value.Values()
+	}
+	return nil
+}
+
+// Walk calls f for value and then, depth first, for each of its descendants.
+// If f returns false the descendants of that value are skipped.
+func Walk(value Value, f func(Value) bool) {
+	if !f(value) {
+		return
+	}
+	for _, child := range Children(value) {
+		Walk(child, f)
+	}
+}
+
+// ChangedSince returns the values at or below path with a revision of at
+// least rev.  Descendants of a returned value are not included, so a rev of
+// 0 returns the value(s) at path itself.
+func (r *Store) ChangedSince(path string, rev uint64) []Value {
+	var ret []Value
+	Walk(r, func(value Value) bool {
+		if value == Value(r) {
+			return true
+		}
+		p := value.Path()
+		if !PathHasPrefix(p, path) {
+			// Only keep going if this value is on the way to path
+			return PathHasPrefix(path, p)
+		}
+		if value.Revision() >= rev {
+			ret = append(ret, value)
+			return false
+		}
+		return true
+	})
+	return ret
+}
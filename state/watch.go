@@ -0,0 +1,102 @@
+package state
+
+import (
+	"strings"
+
+	"github.com/rollerderby/go/json"
+)
+
+type watcher struct {
+	id     uint64
+	prefix string
+	f      func([]Value)
+	patch  func(uint64, json.Patch) // See patch.go
+}
+
+// Watch registers f to be called once per Unlock with the values changed
+// at or below prefix during that lock.  An empty prefix watches the whole
+// tree.  f is called while the lock is still held and must not change the
+// state.  The returned id can be passed to Unwatch.
+func (r *Store) Watch(prefix string, f func([]Value)) uint64 {
+	r.watchMu.Lock()
+	defer r.watchMu.Unlock()
+
+	r.watchID++
+	r.watchers = append(r.watchers, &watcher{id: r.watchID, prefix: prefix, f: f})
+	return r.watchID
+}
+
+func (r *Store) Unwatch(id uint64) {
+	r.watchMu.Lock()
+	defer r.watchMu.Unlock()
+
+	for i, w := range r.watchers {
+		if w.id == id {
+			r.watchers = append(r.watchers[:i], r.watchers[i+1:]...)
+			return
+		}
+	}
+}
+
+func (r *Store) trackChange(value Value) {
+	if r.changedSet == nil {
+		r.changedSet = make(map[Value]bool)
+	}
+	if r.changedSet[value] {
+		return
+	}
+	r.changedSet[value] = true
+	r.changedValues = append(r.changedValues, value)
+}
+
+func (r *Store) notifyWatchers() {
+	changed := r.changedValues
+	r.changedValues = nil
+	r.changedSet = nil
+	if len(changed) == 0 {
+		return
+	}
+
+	r.watchMu.Lock()
+	watchers := append([]*watcher(nil), r.watchers...)
+	r.watchMu.Unlock()
+
+	var patch json.Patch
+	wantsPatch := false
+	for _, w := range watchers {
+		wantsPatch = wantsPatch || w.patch != nil
+	}
+	if wantsPatch && r.patchBase != nil {
+		patch = r.buildPatch(changed)
+	} else {
+		// Nobody is watching, so stop keeping the JSON up to date
+		r.patchBase = nil
+	}
+
+	for _, w := range watchers {
+		if w.patch != nil {
+			if len(patch) > 0 {
+				w.patch(r.revision, patch)
+			}
+			continue
+		}
+
+		var values []Value
+		for _, value := range changed {
+			if PathHasPrefix(value.Path(), w.prefix) {
+				values = append(values, value)
+			}
+		}
+		if len(values) > 0 {
+			w.f(values)
+		}
+	}
+}
+
+// PathHasPrefix reports if path is prefix or is a descendant of prefix
+func PathHasPrefix(path, prefix string) bool {
+	if prefix == "" || path == prefix {
+		return true
+	}
+	return strings.HasPrefix(path, prefix+"[")
+}
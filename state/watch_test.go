@@ -0,0 +1,96 @@
+package state
+
+import "testing"
+
+func TestWatchPrefix(t *testing.T) {
+	s, h := newTestStore(t)
+	s.Lock()
+	first, _ := h.NewEmptyElement("")
+	second, _ := h.NewEmptyElement("")
+	s.Unlock()
+
+	var calls int
+	var got []Value
+	id := s.Watch(first.Path(), func(values []Value) {
+		calls++
+		got = append(got, values...)
+	})
+	all := 0
+	s.Watch("", func(values []Value) { all++ })
+
+	s.Lock()
+	first.(*Object).Get("Name").(*String).SetValue("a")
+	first.(*Object).Get("Secret").(*String).SetValue("b")
+	second.(*Object).Get("Name").(*String).SetValue("c")
+	s.Unlock()
+
+	if calls != 1 || all != 1 {
+		t.Fatalf("Expected one call per Unlock, got %v and %v", calls, all)
+	}
+	if len(got) != 2 {
+		t.Fatalf("Expected 2 changed values, got %v", len(got))
+	}
+	for _, v := range got {
+		if !PathHasPrefix(v.Path(), first.Path()) {
+			t.Fatalf("%v is not below %v", v.Path(), first.Path())
+		}
+	}
+
+	s.Lock()
+	second.(*Object).Get("Name").(*String).SetValue("d")
+	s.Unlock()
+	if calls != 1 {
+		t.Fatal("Watcher called for a change outside its prefix")
+	}
+
+	s.Unwatch(id)
+	s.Lock()
+	first.(*Object).Get("Name").(*String).SetValue("e")
+	s.Unlock()
+	if calls != 1 || all != 3 {
+		t.Fatalf("Unwatch did not stop the watcher: %v, %v", calls, all)
+	}
+}
+
+func TestChangedSince(t *testing.T) {
+	s, h := newTestStore(t)
+	s.Lock()
+	first, _ := h.NewEmptyElement("")
+	second, _ := h.NewEmptyElement("")
+	s.Unlock()
+
+	rev := s.Revision()
+	s.Lock()
+	name := first.(*Object).Get("Name")
+	name.(*String).SetValue("a")
+	s.Unlock()
+
+	s.RLock()
+	defer s.RUnlock()
+	changed := s.ChangedSince(first.Path(), rev)
+	if len(changed) != 1 || changed[0] != name {
+		t.Fatalf("Expected %v, got %v", name.Path(), changed)
+	}
+	if changed := s.ChangedSince(second.Path(), rev); len(changed) != 0 {
+		t.Fatalf("Expected nothing changed in %v, got %v", second.Path(), changed)
+	}
+}
+
+func TestPathHasPrefix(t *testing.T) {
+	for _, c := range []struct {
+		path, prefix string
+		want         bool
+	}{
+		{"Teams[a][Name]", "", true},
+		{"Teams[a][Name]", "Teams", true},
+		{"Teams[a][Name]", "Teams[a]", true},
+		{"Teams[a]", "Teams[a]", true},
+		{"Teams[ab]", "Teams[a", false},
+		{"TeamsX[a]", "Teams", false},
+		{"Teams", "Teams[a]", false},
+	} {
+		if got := PathHasPrefix(c.path, c.prefix); got != c.want {
+			t.Errorf("PathHasPrefix(%q, %q) = %v", c.path, c.prefix, got)
+		}
+	}
+}
@@ -3,3 +3,4 @@
 go get -u github.com/mjibson/esc || exit 1
 go get -u github.com/gorilla/websocket || exit 1
 go get -u github.com/satori/go.uuid || exit 1
+go get -u go.etcd.io/bbolt || exit 1
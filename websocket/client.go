@@ -5,6 +5,7 @@ import "github.com/rollerderby/go/logger"
 type User interface {
 	Username() string
 	Name() string
+	HasGroup(groups ...string) bool
 }
 
 type Client interface {
@@ -0,0 +1,368 @@
+package websocket
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/rollerderby/go/json"
+	"github.com/rollerderby/go/state"
+)
+
+// stateSync lets a client follow parts of the state tree.  The client sends
+//
+//	Register    {"Paths": ["Ruleset", ...], "Revision": 0}
+//	Unregister  {"Paths": ["Ruleset", ...]}
+//	Set         {"Path": "Ruleset[...][Name]", "Value": "New Name", "Revision": 12}
+//	Undo, Redo  (no data)
+//	History     (no data, answered with a History message)
+//
+// and receives State messages holding a Revision and the Values that changed,
+// keyed by path.  Registering sends everything under the paths changed at or
+// after Revision (so 0 is a full snapshot), after that only changes are sent.
+// Once a client has seen Revision N it can reconnect and register with N.
+// Values the client's user cannot read are never sent, and Set is refused for
+// values the user cannot write.  Set's Revision is optional: if given, and the
+// values changed at or after it, nothing is set and a Conflict message holding
+// the Path, the current Revision and the current Value (if readable) is sent
+// instead.
+type stateSync struct {
+	ws      *Websocket
+	member  state.Member
+	user    string
+	mu      sync.Mutex // Protects paths and queue
+	paths   map[string]bool
+	watchID uint64
+	queue   []json.Value
+	wake    chan struct{}
+	done    chan struct{}
+}
+
+var errInvalidPaths = errors.New("Paths must be an array of strings")
+
+// SyncState adds the Register, Unregister and Set handlers to the websocket
+func (ws *Websocket) SyncState() {
+	if ws.sync != nil {
+		return
+	}
+
+	ws.LockWrites = true
+	s := &stateSync{
+		ws:    ws,
+		paths: make(map[string]bool),
+		wake:  make(chan struct{}, 1),
+		done:  make(chan struct{}),
+	}
+	if user := ws.client.User(); user != nil {
+		s.member = user
+		state.Root.View(func() { s.user = user.Username() })
+	}
+	ws.sync = s
+
+	ws.Register("Register", s.register)
+	ws.Register("Unregister", s.unregister)
+	ws.Register("Set", s.set)
+	ws.Register("Undo", s.undo)
+	ws.Register("Redo", s.redo)
+	ws.Register("History", s.history)
+	go s.sendLoop()
+}
+
+func (s *stateSync) close() {
+	state.Root.Unwatch(s.watchID)
+	close(s.done)
+}
+
+func (s *stateSync) register(msg *Message) error {
+	obj, _ := msg.Data.(json.Object)
+	paths, err := getPaths(obj)
+	if err != nil {
+		return s.ws.SendError(err.Error(), err)
+	}
+	var rev uint64
+	if num, ok := obj["Revision"].(*json.Number); ok {
+		if rev, err = num.GetUint64(); err != nil {
+			return s.ws.SendError("Invalid Revision", err)
+		}
+	}
+
+	// Hold the read lock so no changes are missed between the snapshot and the
+	// watch
+	state.Root.RLock()
+	defer state.Root.RUnlock()
+
+	var values []state.Value
+	s.mu.Lock()
+	for _, path := range paths {
+		s.paths[path] = true
+		for _, value := range state.Root.ChangedSince(path, rev) {
+			if state.CanRead(value, s.member) {
+				values = append(values, value)
+			}
+		}
+	}
+	if s.watchID == 0 {
+		s.watchID = state.Root.Watch("", s.changed)
+	}
+	s.mu.Unlock()
+
+	s.push(state.Root.Revision(), topValues(values))
+	return nil
+}
+
+func (s *stateSync) unregister(msg *Message) error {
+	obj, _ := msg.Data.(json.Object)
+	paths, err := getPaths(obj)
+	if err != nil {
+		return s.ws.SendError(err.Error(), err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, path := range paths {
+		delete(s.paths, path)
+	}
+	if len(s.paths) == 0 && s.watchID != 0 {
+		state.Root.Unwatch(s.watchID)
+		s.watchID = 0
+	}
+	return nil
+}
+
+func (s *stateSync) set(msg *Message) error {
+	obj, _ := msg.Data.(json.Object)
+	path, ok := obj["Path"].(*json.String)
+	if !ok {
+		return s.ws.SendError("Path must be a string", nil)
+	}
+	jValue, ok := obj["Value"]
+	if !ok {
+		return s.ws.SendError("Value is missing", nil)
+	}
+	var rev uint64
+	jRev, checkRev := obj["Revision"].(*json.Number)
+	if checkRev {
+		var err error
+		if rev, err = jRev.GetUint64(); err != nil {
+			return s.ws.SendError("Invalid Revision", err)
+		}
+	}
+
+	if reply := s.setLocked(path.Get(), jValue, checkRev, rev); reply != nil {
+		return reply()
+	}
+	return nil
+}
+
+// setLocked does the work of set holding the lock.  Any reply is returned to
+// be sent once the lock is released, so a slow client doesn't hold up others.
+func (s *stateSync) setLocked(path string, jValue json.Value, checkRev bool, rev uint64) func() error {
+	state.Root.Lock()
+	defer state.Root.Unlock()
+	state.Root.SetUser(s.user)
+	state.Root.SetRemoteAddr(s.ws.RemoteAddr())
+
+	values, err := state.Root.Query(path)
+	if err != nil {
+		return s.errorReply(err.Error(), err)
+	}
+	for _, value := range values {
+		if !state.CanWrite(value, s.member) {
+			return s.errorReply(fmt.Sprintf("Permission denied for %q", value.Path()), nil)
+		}
+	}
+	if checkRev {
+		err = state.Root.SetPathIfUnchanged(path, jValue, rev)
+	} else {
+		err = state.Root.SetPath(path, jValue)
+	}
+	if conflict, ok := err.(*state.ErrConflict); ok {
+		return s.conflictReply(conflict)
+	}
+	if err != nil {
+		return s.errorReply(err.Error(), err)
+	}
+	return nil
+}
+
+func (s *stateSync) errorReply(msg string, err error) func() error {
+	return func() error { return s.ws.SendError(msg, err) }
+}
+
+// conflictReply tells the client its Set was based on an old copy.  The
+// message is built here, while the caller holds the lock.  Value is left out
+// if the user may write but not read it.
+func (s *stateSync) conflictReply(conflict *state.ErrConflict) func() error {
+	jRev := &json.Number{}
+	jRev.SetUint64(state.Root.Revision())
+	data := json.Object{
+		"Path":     json.NewString(conflict.Path),
+		"Revision": jRev,
+	}
+	if state.CanRead(conflict.Value, s.member) {
+		data["Value"] = state.ReadableJSON(conflict.Value, s.member)
+	}
+	msg := &Message{Type: "Conflict", Data: data}
+	return func() error { return s.ws.sendMessage(msg) }
+}
+
+func (s *stateSync) undo(msg *Message) error {
+	return s.replay(state.Root.NextUndo, state.Root.Undo)
+}
+
+func (s *stateSync) redo(msg *Message) error {
+	return s.replay(state.Root.NextRedo, state.Root.Redo)
+}
+
+func (s *stateSync) replay(next func() *state.ChangeSet, apply func() (*state.ChangeSet, error)) error {
+	if reply := s.replayLocked(next, apply); reply != nil {
+		return reply()
+	}
+	return nil
+}
+
+func (s *stateSync) replayLocked(next func() *state.ChangeSet, apply func() (*state.ChangeSet, error)) func() error {
+	state.Root.Lock()
+	defer state.Root.Unlock()
+	state.Root.SetUser(s.user)
+	state.Root.SetRemoteAddr(s.ws.RemoteAddr())
+
+	if cs := next(); cs != nil {
+		for _, value := range cs.Values() {
+			if !state.CanWrite(value, s.member) {
+				return s.errorReply(fmt.Sprintf("Permission denied for %q", value.Path()), nil)
+			}
+		}
+	}
+	if _, err := apply(); err != nil {
+		return s.errorReply(err.Error(), err)
+	}
+	return nil
+}
+
+func (s *stateSync) history(msg *Message) error {
+	state.Root.RLock()
+	var arr json.Array
+	for _, cs := range state.Root.History() {
+		arr = append(arr, cs.ReadableJSON(s.member))
+	}
+	state.Root.RUnlock()
+
+	return s.ws.sendMessage(&Message{Type: "History", Data: arr})
+}
+
+// changed is called by state.Root with the lock held
+func (s *stateSync) changed(values []state.Value) {
+	var matched []state.Value
+	s.mu.Lock()
+	for _, value := range values {
+		if !state.CanRead(value, s.member) {
+			continue
+		}
+		for path := range s.paths {
+			if state.PathHasPrefix(value.Path(), path) {
+				matched = append(matched, value)
+				break
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	if len(matched) > 0 {
+		s.push(state.Root.Revision(), topValues(matched))
+	}
+}
+
+// push queues a State message.  The JSON is built here, while the caller
+// holds the lock, but the write happens in sendLoop.
+func (s *stateSync) push(rev uint64, values []state.Value) {
+	jValues := make(json.Object)
+	for _, value := range values {
+		jValues[value.Path()] = state.ReadableJSON(value, s.member)
+	}
+	jRev := &json.Number{}
+	jRev.SetUint64(rev)
+
+	s.mu.Lock()
+	s.queue = append(s.queue, json.Object{"Revision": jRev, "Values": jValues})
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Runs in a goroutine
+func (s *stateSync) sendLoop() {
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.wake:
+		}
+
+		s.mu.Lock()
+		queue := s.queue
+		s.queue = nil
+		s.mu.Unlock()
+
+		for _, data := range queue {
+			select {
+			case <-s.done:
+				return
+			default:
+			}
+			if err := s.ws.sendMessage(&Message{Type: "State", Data: data}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func getPaths(obj json.Object) ([]string, error) {
+	arr, ok := obj["Paths"].(json.Array)
+	if !ok {
+		return nil, errInvalidPaths
+	}
+
+	var ret []string
+	for _, val := range arr {
+		path, ok := val.(*json.String)
+		if !ok {
+			return nil, errInvalidPaths
+		}
+		ret = append(ret, path.Get())
+	}
+	return ret, nil
+}
+
+// topValues drops any value that has an ancestor in values, as sending the
+// ancestor already includes it
+func topValues(values []state.Value) []state.Value {
+	included := make(map[state.Value]bool)
+	for _, value := range values {
+		included[value] = true
+	}
+
+	var ret []state.Value
+	seen := make(map[state.Value]bool)
+	for _, value := range values {
+		if seen[value] {
+			continue
+		}
+		seen[value] = true
+
+		hasAncestor := false
+		for p := value.Parent(); p != nil; p = p.Parent() {
+			if included[p] {
+				hasAncestor = true
+				break
+			}
+		}
+		if !hasAncestor {
+			ret = append(ret, value)
+		}
+	}
+	return ret
+}
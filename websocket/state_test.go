@@ -0,0 +1,268 @@
+package websocket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	gws "github.com/gorilla/websocket"
+	"github.com/rollerderby/go/json"
+	"github.com/rollerderby/go/logger"
+	"github.com/rollerderby/go/state"
+)
+
+type testUser []string
+
+func (u testUser) Username() string { return "test" }
+func (u testUser) Name() string     { return "Test" }
+func (u testUser) HasGroup(groups ...string) bool {
+	for _, g := range groups {
+		for _, h := range u {
+			if g == h {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type testClient struct {
+	user User
+}
+
+func (c *testClient) Close(error)         {}
+func (c *testClient) User() User          { return c.user }
+func (c *testClient) ExtraInfo() string   { return "" }
+func (c *testClient) Log() *logger.Logger { return nil }
+
+// dialState connects to a server syncing state.Root for user
+func dialState(t *testing.T, user User) *gws.Conn {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := New(&testClient{user: user}, w, r)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ws.SyncState()
+		ws.Loop()
+	}))
+	t.Cleanup(srv.Close)
+
+	conn, _, err := gws.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func send(t *testing.T, conn *gws.Conn, msgType string, data json.Value) {
+	msg := &Message{Type: msgType, Data: data}
+	if err := conn.WriteMessage(gws.TextMessage, []byte(msg.JSON().JSON(false))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func receive(t *testing.T, conn *gws.Conn) *Message {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, p, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	jValue, err := json.Decode(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := newMessage(jValue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+// addThings adds a root hash named name with one element, returning the
+// element's path
+func addThings(t *testing.T, name string) string {
+	h := state.NewHashOf(func() state.Value {
+		return &state.Object{Definition: state.ObjectDef{Name: "Thing", Values: []state.ObjectValueDef{
+			{Name: "ID", Initializer: state.NewGUID},
+			{Name: "Name", Initializer: state.NewString},
+			{Name: "Secret", Initializer: state.NewString, ReadGroups: []string{"admin"}, WriteGroups: []string{"admin"}},
+		}}}
+	})()
+	state.Root.Lock()
+	defer state.Root.Unlock()
+	if err := state.Root.Add(name, "", h); err != nil {
+		t.Fatal(err)
+	}
+	elem, err := h.(*state.Hash).NewEmptyElement("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return elem.Path()
+}
+
+func setData(path string, value json.Value) json.Object {
+	return json.Object{"Path": json.NewString(path), "Value": value}
+}
+
+func TestSyncRegisterAndSet(t *testing.T) {
+	elem := addThings(t, "SyncThings")
+	conn := dialState(t, testUser{"user"})
+
+	send(t, conn, "Register", json.Object{"Paths": json.Array{json.NewString("SyncThings")}})
+	msg := receive(t, conn)
+	if msg.Type != "State" {
+		t.Fatalf("Expected State, got %v", msg.JSON().JSON(false))
+	}
+	values := msg.Data.(json.Object)["Values"].(json.Object)
+	thing, ok := values["SyncThings"].(json.Object)[elem[len("SyncThings["):len(elem)-1]].(json.Object)
+	if !ok {
+		t.Fatalf("Snapshot is missing %v: %v", elem, msg.JSON().JSON(false))
+	}
+	if _, ok := thing["Secret"]; ok {
+		t.Fatalf("Snapshot has an unreadable value: %v", msg.JSON().JSON(false))
+	}
+
+	send(t, conn, "Set", setData(elem+"[Name]", json.NewString("a")))
+	msg = receive(t, conn)
+	values = msg.Data.(json.Object)["Values"].(json.Object)
+	if msg.Type != "State" || values[elem+"[Name]"].JSON(false) != `"a"` {
+		t.Fatalf("Expected the change, got %v", msg.JSON().JSON(false))
+	}
+
+	send(t, conn, "Set", setData(elem+"[Secret]", json.NewString("b")))
+	if msg = receive(t, conn); msg.Type != "Error" {
+		t.Fatalf("Expected an Error, got %v", msg.JSON().JSON(false))
+	}
+
+	// Changes outside the registered paths are not sent
+	send(t, conn, "Unregister", json.Object{"Paths": json.Array{json.NewString("SyncThings")}})
+	send(t, conn, "Register", json.Object{"Paths": json.Array{json.NewString(elem + "[ID]")}})
+	receive(t, conn)
+	send(t, conn, "Set", setData(elem+"[Name]", json.NewString("c")))
+	send(t, conn, "Set", setData(elem+"[Secret]", json.NewString("d")))
+	if msg = receive(t, conn); msg.Type != "Error" {
+		t.Fatalf("Expected only the Error, got %v", msg.JSON().JSON(false))
+	}
+}
+
+func TestSyncUndoComputed(t *testing.T) {
+	h := state.NewHashOf(func() state.Value {
+		return &state.Object{Definition: state.ObjectDef{Name: "Rule", Values: []state.ObjectValueDef{
+			{Name: "DefaultValue", Initializer: state.NewString},
+			{Name: "Value", Initializer: state.NewString},
+			{Name: "EffectiveValue", Initializer: state.NewComputed(func(parent state.Value) json.Value {
+				if val := parent.(*state.Object).Get("Value").(*state.String).Value(); val != "" {
+					return json.NewString(val)
+				}
+				return parent.(*state.Object).Get("DefaultValue").JSON(false)
+			})},
+		}}}
+	})()
+	state.Root.SetIsReady(true)
+	state.Root.Lock()
+	if err := state.Root.Add("UndoRules", "", h); err != nil {
+		t.Fatal(err)
+	}
+	rule, err := h.(*state.Hash).NewEmptyElement("r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule.(*state.Object).Get("DefaultValue").(*state.String).SetValue("default")
+	state.Root.Unlock()
+	effective := rule.(*state.Object).Get("EffectiveValue").(*state.Computed)
+
+	conn := dialState(t, testUser{"user"})
+	send(t, conn, "Set", setData(rule.Path()+"[Value]", json.NewString("a")))
+	send(t, conn, "Undo", nil)
+	send(t, conn, "Register", json.Object{"Paths": json.Array{json.NewString(rule.Path())}})
+	if msg := receive(t, conn); msg.Type != "State" {
+		t.Fatalf("Expected State, got %v", msg.JSON().JSON(false))
+	}
+
+	state.Root.RLock()
+	value, got := rule.(*state.Object).Get("Value").(*state.String).Value(), effective.Value().JSON(false)
+	state.Root.RUnlock()
+	if value != "" || got != `"default"` {
+		t.Fatalf("Undo left Value %v and EffectiveValue %v", value, got)
+	}
+
+	send(t, conn, "Redo", nil)
+	msg := receive(t, conn)
+	if msg.Type != "State" || msg.Data.(json.Object)["Values"].(json.Object)[rule.Path()+"[EffectiveValue]"].JSON(false) != `"a"` {
+		t.Fatalf("Expected the redone EffectiveValue, got %v", msg.JSON().JSON(false))
+	}
+}
+
+func TestSyncSetConflict(t *testing.T) {
+	elem := addThings(t, "ConflictThings")
+	conn := dialState(t, testUser{"user"})
+	send(t, conn, "Register", json.Object{"Paths": json.Array{json.NewString(elem)}})
+	msg := receive(t, conn)
+	rev := msg.Data.(json.Object)["Revision"]
+
+	set := setData(elem+"[Name]", json.NewString("a"))
+	set["Revision"] = rev
+	send(t, conn, "Set", set)
+	if msg = receive(t, conn); msg.Type != "State" {
+		t.Fatalf("Expected State, got %v", msg.JSON().JSON(false))
+	}
+
+	// Set again from the same, now old, copy
+	set = setData(elem+"[Name]", json.NewString("b"))
+	set["Revision"] = rev
+	send(t, conn, "Set", set)
+	msg = receive(t, conn)
+	if msg.Type != "Conflict" {
+		t.Fatalf("Expected Conflict, got %v", msg.JSON().JSON(false))
+	}
+	data := msg.Data.(json.Object)
+	state.Root.RLock()
+	current := state.Root.Revision()
+	state.Root.RUnlock()
+	if data["Path"].JSON(false) != `"`+elem+`[Name]"` || data["Value"].JSON(false) != `"a"` {
+		t.Fatalf("Unexpected conflict %v", msg.JSON().JSON(false))
+	}
+	if got, err := data["Revision"].(*json.Number).GetUint64(); err != nil || got != current {
+		t.Fatalf("Expected Revision %v, got %v", current, data["Revision"].JSON(false))
+	}
+}
+
+func TestSyncSecrets(t *testing.T) {
+	h := state.NewHashOf(func() state.Value {
+		return &state.Object{Definition: state.ObjectDef{Name: "User", Values: []state.ObjectValueDef{
+			{Name: "ID", Initializer: state.NewGUID},
+			{Name: "Name", Initializer: state.NewString},
+			{Name: "PasswordHash", Initializer: state.NewString, ReadGroups: []string{"admin"}, WriteGroups: []string{"admin"}, Secret: true},
+		}}}
+	})()
+	state.Root.Lock()
+	if err := state.Root.Add("SecretUsers", "", h); err != nil {
+		t.Fatal(err)
+	}
+	elem, _ := h.(*state.Hash).NewEmptyElement("")
+	elem.(*state.Object).Get("PasswordHash").(*state.String).SetValue("first")
+	state.Root.Unlock()
+
+	conn := dialState(t, testUser{"admin"})
+	send(t, conn, "Register", json.Object{"Paths": json.Array{json.NewString("SecretUsers")}})
+	if msg := receive(t, conn); strings.Contains(msg.JSON().JSON(false), "first") {
+		t.Fatalf("Secret sent on Register: %v", msg.JSON().JSON(false))
+	}
+
+	// Writing it is still allowed, but the change is not sent
+	send(t, conn, "Set", setData(elem.Path()+"[PasswordHash]", json.NewString("second")))
+	send(t, conn, "Set", setData(elem.Path()+"[Name]", json.NewString("a")))
+	msg := receive(t, conn)
+	if msg.Type != "State" || strings.Contains(msg.JSON().JSON(false), "second") {
+		t.Fatalf("Expected only the Name change, got %v", msg.JSON().JSON(false))
+	}
+	state.Root.RLock()
+	defer state.Root.RUnlock()
+	if got := elem.(*state.Object).Get("PasswordHash").(*state.String).Value(); got != "second" {
+		t.Fatalf("PasswordHash is %q", got)
+	}
+}
@@ -12,11 +12,14 @@ import (
 	gws "github.com/gorilla/websocket"
 	"github.com/rollerderby/go/json"
 	"github.com/rollerderby/go/logger"
+	"github.com/rollerderby/go/state"
 )
 
 var websockets []*Websocket
 var mux sync.Mutex
 
+var errClosed = errors.New("Websocket is closed")
+
 type PacketInfo struct {
 	packets int64
 	bytes   int64
@@ -43,6 +46,7 @@ type Websocket struct {
 	log        *logger.Logger
 	conn       *gws.Conn
 	handlers   []*handler
+	sync       *stateSync
 	path       string
 	sent       PacketInfo
 	recv       PacketInfo
@@ -106,7 +110,7 @@ func WebsocketInfos() []*WebsocketInfo {
 	for _, ws := range websockets {
 		username, fullname := "", ""
 		if user := ws.client.User(); user != nil {
-			username, fullname = user.Username(), user.Name()
+			state.Root.View(func() { username, fullname = user.Username(), user.Name() })
 		}
 		ret = append(ret, &WebsocketInfo{
 			Path:       ws.path,
@@ -161,7 +165,7 @@ func (ws *Websocket) Debug(d bool) {
 }
 
 func (ws *Websocket) RemoteAddr() string {
-	if ws != nil || ws.conn != nil {
+	if ws != nil && ws.conn != nil {
 		return ws.conn.RemoteAddr().String()
 	}
 	return "DISCONNECTED"
@@ -187,6 +191,9 @@ func (ws *Websocket) writeJSON(v json.Value) error {
 		defer ws.Unlock()
 	}
 
+	if ws.conn == nil {
+		return errClosed
+	}
 	if err := ws.conn.WriteMessage(gws.TextMessage, []byte(data)); err != nil {
 		return err
 	}
@@ -198,20 +205,35 @@ func (ws *Websocket) writeJSON(v json.Value) error {
 
 func (ws *Websocket) sendMessage(msg *Message) error {
 	if strings.ToLower(msg.Type) != "pong" {
-		ws.log.Debugf("%v  Sending %+v", ws.conn.RemoteAddr(), msg)
+		ws.log.Debugf("%v  Sending %+v", ws.RemoteAddr(), msg)
 	}
 
 	err := ws.writeJSON(msg.JSON())
 	if err != nil {
-		ws.log.Errorf("%v  Could not write message: %v", ws.conn.RemoteAddr(), err)
+		ws.log.Errorf("%v  Could not write message: %v", ws.RemoteAddr(), err)
 		return err
 	}
 
-	ws.lastActive = time.Now()
+	ws.setActive()
 	return nil
 }
 
+// setActive records when the websocket was last used.  With LockWrites,
+// messages can be sent from other goroutines, so it takes the lock too.
+func (ws *Websocket) setActive() {
+	if ws.LockWrites {
+		ws.Lock()
+		defer ws.Unlock()
+	}
+	ws.lastActive = time.Now()
+}
+
 func (ws *Websocket) Close() {
+	if ws.sync != nil {
+		ws.sync.close()
+		ws.sync = nil
+	}
+
 	if ws.client != nil {
 		ws.client.Close(nil)
 		ws.client = nil
@@ -219,6 +241,10 @@ func (ws *Websocket) Close() {
 
 	if ws.conn != nil {
 		unregister(ws)
+		if ws.LockWrites {
+			ws.Lock()
+			defer ws.Unlock()
+		}
 		ws.conn.Close()
 		ws.conn = nil
 	}
@@ -263,7 +289,7 @@ func (ws *Websocket) Loop() {
 			return
 		}
 
-		ws.lastActive = time.Now()
+		ws.setActive()
 		t := strings.ToLower(msg.Type)
 		if t == "ping" {
 			ws.SendResponse("pong", nil)